@@ -0,0 +1,525 @@
+package skeleton
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// backupMagic identifies a backup stream.
+var backupMagic = []byte("skdbbak1")
+
+// ErrInvalidBackup is returned when restoring a stream that isn't a complete
+// backup.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// ErrBackupGap is returned when an incremental backup doesn't start where the
+// backup it's layered over ends.
+var ErrBackupGap = errors.New("incremental backup doesn't follow the previous backup")
+
+// ErrRestoreNotEmpty is returned when restoring a full backup into a database
+// that already has keys.
+var ErrRestoreNotEmpty = errors.New("full backups can only be restored into an empty database")
+
+// ErrRestoreOutOfOrder is returned when restoring a version of a key that is
+// older than a version the database already has.
+var ErrRestoreOutOfOrder = errors.New("the database has a newer version of a restored key")
+
+// Backup kinds.
+const (
+	fullBackup byte = iota
+	incrementalBackup
+)
+
+// Backup record types.
+const (
+	backupEnd byte = iota
+	backupNamespace
+	backupEntry
+	backupVersion
+)
+
+// backupHeader is the start of a backup stream. Full backups hold the versions
+// visible at until, and incremental backups the versions in (since, until].
+type backupHeader struct {
+	kind         byte
+	since, until int64
+}
+
+// Backup writes a consistent snapshot of every key in every namespace of the
+// database, as of the specified time, to w. The zero time backs up the current
+// time. Since the snapshot is read with the same time semantics as GetAt, it
+// doesn't block writers or the worker. Index entries aren't backed up, since
+// they're rebuilt from the keys when they're restored.
+func (db *DB) Backup(w io.Writer, at time.Time) error {
+	if isZeroTime(at) {
+		at = db.now()
+	}
+	at = db.snapshot(at)
+	bw := bufio.NewWriter(w)
+	var e encoder
+	e.buf = append(e.buf, backupMagic...)
+	e.byte(fullBackup)
+	e.varint(at.UnixNano())
+
+	for _, ns := range db.userNamespaces() {
+		cmp := ns.comparator()
+		if cmp == nil {
+			return errors.Errorf("namespace %q must be opened with its comparator before it's backed up", ns.name)
+		}
+		e.byte(backupNamespace)
+		e.bytes([]byte(ns.name))
+		e.bytes([]byte(cmp.Name()))
+
+		it := db.newIterator(ns, nil, nil, nil, at)
+		for it.Next() {
+			entry := it.entries[it.i]
+			var flags byte
+			if entry.value == nil {
+				flags |= valueNil
+			}
+			e.byte(backupEntry)
+			e.bytes(entry.key)
+			e.byte(flags)
+			e.bytes(entry.value)
+			e.varint(entry.time.UnixNano())
+			if err := writeBackupBuffer(bw, &e, false); err != nil {
+				return err
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	e.byte(backupEnd)
+	return writeBackupBuffer(bw, &e, true)
+}
+
+// BackupSince writes every version committed in (since, until] to w, including
+// deletes, so it can be restored over a backup taken at since. The zero since
+// includes every version and the zero until is the current time. Versions are
+// selected by their timestamps, like GetAt, so transactions that commit after
+// until are left for the next incremental backup.
+func (db *DB) BackupSince(w io.Writer, since, until time.Time) error {
+	if isZeroTime(since) {
+		since = zeroTime
+	}
+	if isZeroTime(until) {
+		until = db.now()
+	}
+	until = db.snapshot(until)
+	if !until.After(since) {
+		return ErrInvalidRange
+	}
+	bw := bufio.NewWriter(w)
+	var e encoder
+	e.buf = append(e.buf, backupMagic...)
+	e.byte(incrementalBackup)
+	e.varint(since.UnixNano())
+	e.varint(until.UnixNano())
+
+	for _, ns := range db.userNamespaces() {
+		cmp := ns.comparator()
+		if cmp == nil {
+			return errors.Errorf("namespace %q must be opened with its comparator before it's backed up", ns.name)
+		}
+		e.byte(backupNamespace)
+		e.bytes([]byte(ns.name))
+		e.bytes([]byte(cmp.Name()))
+
+		_, d, _, err := db.firstLeaf(ns)
+		if err != nil {
+			return err
+		}
+		for {
+			for _, k := range chainKeys(ns, d) {
+				values := db.chainHistory(ns, d, k)
+				// Write the oldest first, so they're restored in order.
+				for i := len(values) - 1; i >= 0; i-- {
+					v := values[i]
+					if !v.time.After(since) || v.time.After(until) {
+						continue
+					}
+					var flags byte
+					if v.tombstone {
+						flags |= valueTombstone
+					}
+					if v.value == nil {
+						flags |= valueNil
+					}
+					e.byte(backupVersion)
+					e.bytes(k)
+					e.byte(flags)
+					e.bytes(v.value)
+					e.varint(v.time.UnixNano())
+				}
+				if err := writeBackupBuffer(bw, &e, false); err != nil {
+					return err
+				}
+			}
+			next := d.getPage().next
+			if next == 0 {
+				break
+			}
+			if d, err = db.getChain(next); err != nil {
+				return err
+			}
+		}
+	}
+	e.byte(backupEnd)
+	return writeBackupBuffer(bw, &e, true)
+}
+
+// writeBackupBuffer writes the encoded records once there are enough of them,
+// or always if final is set, in which case the writer is also flushed.
+func writeBackupBuffer(bw *bufio.Writer, e *encoder, final bool) error {
+	if !final && len(e.buf) < 4096 {
+		return nil
+	}
+	if _, err := bw.Write(e.buf); err != nil {
+		return err
+	}
+	e.buf = e.buf[:0]
+	if final {
+		return bw.Flush()
+	}
+	return nil
+}
+
+// chainKeys returns the keys with committed writes in the delta chain of a
+// data page, in order.
+func chainKeys(ns *Namespace, d *delta) [][]byte {
+	var keys [][]byte
+	for ; d != nil; d = d.next {
+		if d.page != nil {
+			keys = append(keys, d.page.keysAt(0, len(d.page.keys))...)
+			continue
+		}
+		// Range deletes only cover keys that were written before them.
+		if d.key.read || d.key.end != nil || !d.key.committed() {
+			continue
+		}
+		keys = append(keys, d.key.key)
+	}
+	cmp := ns.comparator()
+	sort.Sort(byCmp{keys, cmp})
+	unique := keys[:0]
+	for i, k := range keys {
+		if i == 0 || cmp.Compare(keys[i-1], k) != 0 {
+			unique = append(unique, k)
+		}
+	}
+	return unique
+}
+
+// Restore writes the keys of a full or incremental backup into the database,
+// keeping the times of their versions. Namespaces that don't exist are created
+// with the DB's config. Named namespaces with a comparator other than the DB's
+// or BytewiseComparator must be opened before they can be restored.
+//
+// A full backup can only be restored into an empty database, and an
+// incremental backup only over keys that have no versions newer than its own,
+// so restored versions are never ordered before newer ones. Indexes that exist
+// when the keys are restored are updated.
+func (db *DB) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	h, err := readBackupHeader(br)
+	if err != nil {
+		return err
+	}
+	if h.kind == fullBackup && !db.empty() {
+		return ErrRestoreNotEmpty
+	}
+	return db.restoreRecords(br)
+}
+
+// RestoreIncremental restores a full backup followed by incremental backups
+// layered over it, in the order they were taken. Each incremental backup must
+// start at the time the previous backup ends, or ErrBackupGap is returned.
+func (db *DB) RestoreIncremental(full io.Reader, incrementals ...io.Reader) error {
+	br := bufio.NewReader(full)
+	h, err := readBackupHeader(br)
+	if err != nil {
+		return err
+	}
+	if h.kind != fullBackup {
+		return errors.Wrap(ErrInvalidBackup, "not a full backup")
+	}
+	if !db.empty() {
+		return ErrRestoreNotEmpty
+	}
+	if err := db.restoreRecords(br); err != nil {
+		return err
+	}
+	until := h.until
+	for i, r := range incrementals {
+		br := bufio.NewReader(r)
+		h, err := readBackupHeader(br)
+		if err != nil {
+			return errors.Wrapf(err, "incremental backup %d", i)
+		}
+		if h.kind != incrementalBackup {
+			return errors.Wrapf(ErrInvalidBackup, "incremental backup %d is a full backup", i)
+		}
+		if h.since != until {
+			return errors.Wrapf(ErrBackupGap, "incremental backup %d", i)
+		}
+		if err := db.restoreRecords(br); err != nil {
+			return errors.Wrapf(err, "incremental backup %d", i)
+		}
+		until = h.until
+	}
+	return nil
+}
+
+// readBackupHeader reads and validates the header of a backup stream.
+func readBackupHeader(br *bufio.Reader) (backupHeader, error) {
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != string(backupMagic) {
+		return backupHeader{}, ErrInvalidBackup
+	}
+	d := streamDecoder{r: br}
+	h := backupHeader{kind: d.byte()}
+	switch h.kind {
+	case fullBackup:
+		h.until = d.varint()
+	case incrementalBackup:
+		h.since = d.varint()
+		h.until = d.varint()
+	default:
+		if d.err == nil {
+			return h, errors.Wrapf(ErrInvalidBackup, "unknown backup kind %d", h.kind)
+		}
+	}
+	if d.err != nil {
+		return h, errors.Wrap(ErrInvalidBackup, "truncated")
+	}
+	return h, nil
+}
+
+// restoreRecords writes the records following the header of a backup stream.
+func (db *DB) restoreRecords(br *bufio.Reader) error {
+	d := streamDecoder{r: br}
+	var ns *Namespace
+	// skip is set while reading a reserved namespace, which older backups
+	// include.
+	var skip bool
+	var latest int64
+	for d.err == nil {
+		switch typ := d.byte(); typ {
+		case backupEnd:
+			if d.err != nil {
+				break
+			}
+			db.clock.update(time.Unix(0, latest))
+			return nil
+		case backupNamespace:
+			name, cmpName := string(d.bytes()), string(d.bytes())
+			if d.err != nil {
+				break
+			}
+			if skip = strings.HasPrefix(name, reservedPrefix); skip {
+				continue
+			}
+			var err error
+			if ns, err = db.restoreNamespace(name, cmpName); err != nil {
+				return err
+			}
+		case backupEntry:
+			k, flags, v, t := d.bytes(), d.byte(), d.bytes(), d.varint()
+			if d.err != nil || skip {
+				break
+			}
+			if flags&valueNil != 0 {
+				v = nil
+			}
+			if err := db.restoreValue(ns, k, value{value: v, time: time.Unix(0, t)}); err != nil {
+				return err
+			}
+			if t > latest {
+				latest = t
+			}
+		case backupVersion:
+			k, flags, v, t := d.bytes(), d.byte(), d.bytes(), d.varint()
+			if d.err != nil || skip {
+				break
+			}
+			tombstone := flags&valueTombstone != 0
+			if tombstone || flags&valueNil != 0 {
+				v = nil
+			}
+			if err := db.restoreValue(ns, k, value{value: v, tombstone: tombstone, time: time.Unix(0, t)}); err != nil {
+				return err
+			}
+			if t > latest {
+				latest = t
+			}
+		default:
+			if d.err == nil {
+				return errors.Wrapf(ErrInvalidBackup, "unknown record type %d", typ)
+			}
+		}
+	}
+	if d.err == io.EOF || d.err == io.ErrUnexpectedEOF {
+		return errors.Wrap(ErrInvalidBackup, "truncated")
+	}
+	return d.err
+}
+
+// restoreValue writes a version of a key restored from a backup, and updates
+// the indexes of the namespace to match.
+func (db *DB) restoreValue(ns *Namespace, k []byte, v value) error {
+	if ns == nil {
+		return errors.Wrap(ErrInvalidBackup, "entry before namespace")
+	}
+	if err := db.checkMemoryLimit(); err != nil {
+		return err
+	}
+	_, d, _, err := db.findLeaf(ns, k)
+	if err != nil {
+		return err
+	}
+	l, _ := db.lookupChain(ns, d, nil, k, zeroTime)
+	if l.found && !v.time.After(l.time) {
+		return errors.Wrapf(ErrRestoreOutOfOrder, "key %q", k)
+	}
+	if _, err := db.insert(ns, &key{key: k, values: []value{v}}, k, false); err != nil {
+		return err
+	}
+	if !ns.indexed() {
+		return nil
+	}
+	newLookup, err := db.get(ns, nil, k, zeroTime)
+	if err != nil {
+		return err
+	}
+	old, _ := db.resolve(k, &l)
+	new, _ := db.resolve(k, &newLookup)
+	return db.updateIndexEntries(ns, nil, k, old, new, l.exists(), newLookup.exists())
+}
+
+// restoreNamespace returns the namespace to restore the keys of a backed up
+// namespace into.
+func (db *DB) restoreNamespace(name, cmpName string) (*Namespace, error) {
+	var c *Config
+	if db.getNamespace(name) == nil && name != "" {
+		switch cmpName {
+		case db.ns.comparator().Name():
+		case BytewiseComparator.Name():
+			config := db.config
+			config.Comparator = BytewiseComparator
+			c = &config
+		default:
+			// Don't create the namespace with the wrong comparator.
+			return nil, ErrComparatorMismatch
+		}
+	}
+	ns, err := db.namespace(name, c)
+	if err != nil {
+		return nil, err
+	}
+	if cmp := ns.comparator(); cmp == nil || cmp.Name() != cmpName {
+		return nil, ErrComparatorMismatch
+	}
+	return ns, nil
+}
+
+// userNamespaces returns the namespaces that aren't reserved for internal use,
+// in the same order as allNamespaces.
+func (db *DB) userNamespaces() []*Namespace {
+	var namespaces []*Namespace
+	for _, ns := range db.allNamespaces() {
+		if !strings.HasPrefix(ns.name, reservedPrefix) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// empty returns whether none of the namespaces that aren't reserved have any
+// keys.
+func (db *DB) empty() bool {
+	for _, ns := range db.userNamespaces() {
+		if db.newIterator(ns, nil, nil, nil, zeroTime).Next() {
+			return false
+		}
+	}
+	return true
+}
+
+// allNamespaces returns the default namespace followed by the named namespaces
+// in order of their names.
+func (db *DB) allNamespaces() []*Namespace {
+	namespaces := []*Namespace{db.ns}
+	if m := (*map[string]*Namespace)(atomic.LoadPointer(&db.namespaces)); m != nil {
+		var named []*Namespace
+		for _, ns := range *m {
+			named = append(named, ns)
+		}
+		sort.Sort(byName(named))
+		namespaces = append(namespaces, named...)
+	}
+	return namespaces
+}
+
+// byName implements sort.Interface for []*Namespace based on their names.
+type byName []*Namespace
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].name < a[j].name }
+
+// streamDecoder is like decoder, but reads from a stream. After the first
+// error, all reads return zero values.
+type streamDecoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *streamDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	d.err = err
+	return b
+}
+
+func (d *streamDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(d.r)
+	d.err = err
+	return x
+}
+
+func (d *streamDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(d.r)
+	d.err = err
+	return x
+}
+
+func (d *streamDecoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	// Copy rather than allocating n bytes up front, since n may be corrupt.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		d.err = err
+		return nil
+	}
+	return append([]byte{}, buf.Bytes()...)
+}
@@ -0,0 +1,381 @@
+package skeleton
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+)
+
+func TestBackup(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 200
+
+	db := splitDB(t, count)
+	defer db.Close()
+
+	ns, err := db.Namespace("ns", &Config{MaxKeysPerNode: 10, MaxDeltaCount: 10, Comparator: reverseComparator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Put([]byte("a"), []byte("1"))
+	ns.Put([]byte("b"), []byte{})
+	if err := db.CreateIndex("index", func(k, v []byte) [][]byte { return [][]byte{v[:1]} }); err != nil {
+		t.Fatal(err)
+	}
+	db.Delete(intToKey(7))
+
+	at := db.now()
+	// Writes after the backup time aren't part of the backup.
+	db.Put(intToKey(8), []byte("after"))
+	db.Delete(intToKey(9))
+	txn := db.NewTxn()
+	txn.Put([]byte("pending"), []byte("pending"))
+
+	var buf bytes.Buffer
+	if err := db.Backup(&buf, at); err != nil {
+		t.Fatal(err)
+	}
+
+	// Namespaces with other comparators must be opened first.
+	mismatched, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mismatched.Close()
+	if err := mismatched.Restore(bytes.NewReader(buf.Bytes())); errors.Cause(err) != ErrComparatorMismatch {
+		t.Fatalf("mismatched.Restore = %+v; not ErrComparatorMismatch", err)
+	}
+
+	restored, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	restoredNS, err := restored.Namespace("ns", &Config{MaxKeysPerNode: 10, MaxDeltaCount: 10, Comparator: reverseComparator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		want, _ := db.GetAt(k, at)
+		if v, _ := restored.Get(k); !bytes.Equal(v, want) {
+			t.Errorf("restored.Get(%q) = %q; not %q", k, v, want)
+		}
+	}
+	if v, ok := restored.Get([]byte("pending")); ok {
+		t.Errorf("restored.Get(pending) = %q; should not be ok", v)
+	}
+	if v, _ := restoredNS.Get([]byte("a")); !bytes.Equal(v, []byte("1")) {
+		t.Errorf("restoredNS.Get(a) = %q; not 1", v)
+	}
+	if v, ok := restoredNS.Get([]byte("b")); !ok || v == nil || len(v) != 0 {
+		t.Errorf("restoredNS.Get(b) = %q, %t; not empty", v, ok)
+	}
+
+	// Versions keep their times and new writes are newer.
+	k := intToKey(10)
+	if v, _ := restored.GetAt(k, at.Add(-1)); !bytes.Equal(v, k) {
+		t.Errorf("restored.GetAt(%q, at) = %q; not %q", k, v, k)
+	}
+	if !restored.now().After(at) {
+		t.Errorf("restored.now() should be after the backup")
+	}
+
+	// Index entries aren't backed up, but are rebuilt once the index is defined
+	// again.
+	if err := restored.CreateIndex("index", func(k, v []byte) [][]byte { return [][]byte{v[:1]} }); err != nil {
+		t.Fatal(err)
+	}
+	var want [][]byte
+	for i := 0; i < count; i++ {
+		if i != 7 {
+			want = append(want, intToKey(i))
+		}
+	}
+	sort.Sort(byCmp{want, BytewiseComparator})
+	got, err := restored.IndexScan("index", []byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored.IndexScan = %q; not %q", got, want)
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("b"))
+
+	var buf bytes.Buffer
+	if err := db.Backup(&buf, zeroTime); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	for _, b := range [][]byte{
+		nil,
+		[]byte("not a backup"),
+		buf.Bytes()[:buf.Len()-1],
+	} {
+		if err := restored.Restore(bytes.NewReader(b)); errors.Cause(err) != ErrInvalidBackup {
+			t.Errorf("restored.Restore(%q) = %+v; not ErrInvalidBackup", b, err)
+		}
+	}
+}
+
+// TestRestoreOrder tests that restored versions are never ordered before newer
+// versions that are already in the database.
+func TestRestoreOrder(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"))
+	t0 := db.now()
+	db.Put([]byte("a"), []byte("2"))
+	t1 := db.now()
+
+	var full, inc bytes.Buffer
+	if err := db.Backup(&full, t0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BackupSince(&inc, t0, t1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Restore(bytes.NewReader(full.Bytes())); err != ErrRestoreNotEmpty {
+		t.Fatalf("db.Restore(full) = %+v; not ErrRestoreNotEmpty", err)
+	}
+	if err := db.RestoreIncremental(bytes.NewReader(full.Bytes())); err != ErrRestoreNotEmpty {
+		t.Fatalf("db.RestoreIncremental(full) = %+v; not ErrRestoreNotEmpty", err)
+	}
+
+	db.Put([]byte("a"), []byte("3"))
+	if err := db.Restore(bytes.NewReader(inc.Bytes())); errors.Cause(err) != ErrRestoreOutOfOrder {
+		t.Fatalf("db.Restore(inc) = %+v; not ErrRestoreOutOfOrder", err)
+	}
+	if v, _ := db.Get([]byte("a")); !bytes.Equal(v, []byte("3")) {
+		t.Fatalf("db.Get(a) = %q; not 3", v)
+	}
+}
+
+// TestRestoreIndex tests that restoring keys updates the indexes that already
+// exist, and that index entries in a backup aren't restored directly.
+func TestRestoreIndex(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("1"), []byte("red:apple"))
+	db.Put([]byte("2"), []byte("green:pear"))
+	t0 := db.now()
+	db.Put([]byte("1"), []byte("green:apple"))
+	t1 := db.now()
+
+	var full, inc bytes.Buffer
+	if err := db.Backup(&full, t0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BackupSince(&inc, t0, t1); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if err := restored.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.RestoreIncremental(bytes.NewReader(full.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	indexScanEquals(t, restored, "red", "1")
+	indexScanEquals(t, restored, "green", "2")
+	if err := restored.Restore(bytes.NewReader(inc.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	indexScanEquals(t, restored, "red")
+	indexScanEquals(t, restored, "green", "1", "2")
+	if len(restored.allNamespaces()) != len(db.allNamespaces()) {
+		t.Fatalf("restored.allNamespaces() = %d namespaces; not %d", len(restored.allNamespaces()), len(db.allNamespaces()))
+	}
+}
+
+func TestBackupSince(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	db := splitDB(t, count)
+	defer db.Close()
+
+	backup := func(since, until time.Time) []byte {
+		var buf bytes.Buffer
+		var err error
+		if isZeroTime(since) && !isZeroTime(until) {
+			err = db.Backup(&buf, until)
+		} else {
+			err = db.BackupSince(&buf, since, until)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	t0 := db.now()
+	full := backup(time.Time{}, t0)
+
+	db.Put(intToKey(1), []byte("a"))
+	db.Put(intToKey(1), []byte("b"))
+	db.Delete(intToKey(2))
+	if err := db.DeleteRange(intToKey(3), intToKey(4)); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("new"), []byte("new"))
+	txn := db.NewTxn()
+	txn.Put(intToKey(6), []byte("pending"))
+	t1 := db.now()
+	inc1 := backup(t0, t1)
+	txn.Close()
+
+	db.Put(intToKey(5), []byte("c"))
+	t2 := db.now()
+	inc2 := backup(t1, t2)
+	db.Put(intToKey(5), []byte("after"))
+
+	newDB := func() *DB {
+		db, err := NewDB(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	checkRestored := func(restored *DB) {
+		for i := 0; i <= count; i++ {
+			k := intToKey(i)
+			if i == count {
+				k = []byte("new")
+			}
+			// Deleted keys are found in the DB as tombstones, but not restored.
+			want, _ := db.GetAt(k, t2)
+			if v, _ := restored.Get(k); !bytes.Equal(v, want) {
+				t.Errorf("restored.Get(%q) = %q; not %q", k, v, want)
+			}
+		}
+	}
+
+	restored := newDB()
+	defer restored.Close()
+	if err := restored.RestoreIncremental(bytes.NewReader(full), bytes.NewReader(inc1), bytes.NewReader(inc2)); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(restored)
+	var values [][]byte
+	for _, v := range restored.History(intToKey(1), t0, time.Time{}) {
+		values = append(values, v.Value)
+	}
+	if want := [][]byte{[]byte("b"), []byte("a")}; !reflect.DeepEqual(values, want) {
+		t.Errorf("restored.History(%q) = %q; not %q", intToKey(1), values, want)
+	}
+
+	// An incremental backup since the zero time holds everything.
+	everything := newDB()
+	defer everything.Close()
+	if err := everything.Restore(bytes.NewReader(backup(time.Time{}, t2))); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(everything)
+
+	// An incremental backup only holds the versions in its range.
+	partial := newDB()
+	defer partial.Close()
+	if err := partial.Restore(bytes.NewReader(inc2)); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for it := partial.NewIterator(nil, nil); it.Next(); {
+		keys = append(keys, string(it.Key()))
+	}
+	if want := []string{"key5"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("partial keys = %q; not %q", keys, want)
+	}
+
+	gap := newDB()
+	defer gap.Close()
+	if err := gap.RestoreIncremental(bytes.NewReader(full), bytes.NewReader(inc2)); errors.Cause(err) != ErrBackupGap {
+		t.Errorf("gap.RestoreIncremental = %+v; not ErrBackupGap", err)
+	}
+	if err := gap.RestoreIncremental(bytes.NewReader(inc1)); errors.Cause(err) != ErrInvalidBackup {
+		t.Errorf("gap.RestoreIncremental(inc1) = %+v; not ErrInvalidBackup", err)
+	}
+	if err := db.BackupSince(&bytes.Buffer{}, t1, t0); err != ErrInvalidRange {
+		t.Errorf("db.BackupSince(t1, t0) = %+v; not ErrInvalidRange", err)
+	}
+}
+
+// TestBackupNilValue tests that full and incremental backups keep nil and
+// empty values distinct.
+func TestBackupNilValue(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Put([]byte("nil"), nil)
+	db.Put([]byte("empty"), []byte{})
+
+	var full, inc bytes.Buffer
+	if err := db.Backup(&full, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BackupSince(&inc, time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range []*bytes.Buffer{&full, &inc} {
+		restored, err := NewDB(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.Restore(buf); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := restored.Get([]byte("nil")); !ok || v != nil {
+			t.Errorf("restored.Get(nil) = %#v, %t; not nil", v, ok)
+		}
+		if v, ok := restored.Get([]byte("empty")); !ok || v == nil || len(v) != 0 {
+			t.Errorf("restored.Get(empty) = %#v, %t; not empty", v, ok)
+		}
+		restored.Close()
+	}
+}
@@ -0,0 +1,30 @@
+package skeleton
+
+import "testing"
+
+// BenchmarkGetLargePage benchmarks Gets of the keys of a single consolidated
+// page, which are found by binary search.
+func BenchmarkGetLargePage(b *testing.B) {
+	const count = 10000
+	c := DefaultConfig
+	c.MaxKeysPerNode = count
+	db, err := NewDB(&c)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			b.Fatal(err)
+		}
+	}
+	consolidateAll(db)
+	if d := db.getPage(rootPage).next; d.page == nil || len(d.page.keys) != count {
+		b.Fatalf("page %d should be consolidated with %d keys", rootPage, count)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Get(intToKey(i % count))
+	}
+}
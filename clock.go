@@ -0,0 +1,75 @@
+package skeleton
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the physical time that the DB's hybrid logical clock follows.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// wallClock is a Clock that returns the wall clock time.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+// maxLogical bounds the logical part of a hybrid timestamp. The physical part
+// is kept to the microsecond, so the logical part fits in the nanoseconds of
+// the time.
+const maxLogical = int64(time.Microsecond)
+
+// hybridClock is a hybrid logical clock. Its timestamps have a physical part,
+// which follows physical, and a logical part, which is incremented when the
+// physical time stalls or goes backwards. Timestamps are strictly increasing,
+// and are packed into a time.Time as the physical part plus the logical part
+// in nanoseconds.
+type hybridClock struct {
+	physical Clock
+
+	mu sync.Mutex
+	// wall is the physical part in nanoseconds, a multiple of maxLogical.
+	wall    int64
+	logical int64
+}
+
+// newHybridClock returns a new hybrid logical clock that follows physical.
+func newHybridClock(physical Clock) *hybridClock {
+	return &hybridClock{physical: physical}
+}
+
+// Now implements Clock.
+func (c *hybridClock) Now() time.Time {
+	pt := c.physical.Now().UnixNano()
+	pt -= pt % maxLogical
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt > c.wall {
+		c.wall, c.logical = pt, 0
+	} else if c.logical++; c.logical == maxLogical {
+		c.wall, c.logical = c.wall+maxLogical, 0
+	}
+	return time.Unix(0, c.wall+c.logical)
+}
+
+// update advances the clock so that every later timestamp is after t.
+func (c *hybridClock) update(t time.Time) {
+	ts := t.UnixNano()
+	wall, logical := ts-ts%maxLogical, ts%maxLogical
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wall > c.wall || wall == c.wall && logical > c.logical {
+		c.wall, c.logical = wall, logical
+	}
+}
+
+// now returns a new timestamp from the DB's hybrid logical clock.
+func (db *DB) now() time.Time {
+	return db.clock.Now()
+}
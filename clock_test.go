@@ -0,0 +1,136 @@
+package skeleton
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// TestHybridClock tests that the hybrid clock never returns the same time
+// twice, even when called concurrently.
+func TestHybridClock(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 1000
+	const workers = 10
+
+	c := newHybridClock(wallClock{})
+	times := make([][]time.Time, workers)
+	var done sync.WaitGroup
+	for i := range times {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			for j := 0; j < count; j++ {
+				times[i] = append(times[i], c.Now())
+			}
+		}(i)
+	}
+	done.Wait()
+
+	seen := map[time.Time]bool{}
+	for _, ts := range times {
+		for _, ts := range ts {
+			if seen[ts] {
+				t.Fatalf("c.Now() = %s returned twice", ts)
+			}
+			seen[ts] = true
+		}
+		for j := 1; j < len(ts); j++ {
+			if !ts[j].After(ts[j-1]) {
+				t.Fatalf("c.Now() = %s; not after %s", ts[j], ts[j-1])
+			}
+		}
+	}
+}
+
+// TestHybridClockLogical tests that the logical part of the hybrid clock counts
+// up while the physical time stalls, and is reset when it moves on.
+func TestHybridClockLogical(t *testing.T) {
+	physical := time.Unix(100, 0)
+	c := newHybridClock(fixedClock(physical))
+	for i := 0; i < 3; i++ {
+		if got, want := c.Now(), physical.Add(time.Duration(i)); !got.Equal(want) {
+			t.Fatalf("c.Now() = %s; not %s", got, want)
+		}
+	}
+
+	// Timestamps observed from elsewhere move the clock forwards, but never
+	// backwards.
+	later := physical.Add(time.Millisecond + 5)
+	c.update(later)
+	c.update(physical)
+	if got, want := c.Now(), later.Add(1); !got.Equal(want) {
+		t.Fatalf("c.Now() = %s; not %s", got, want)
+	}
+
+	c.physical = fixedClock(physical.Add(time.Second + 7))
+	if got, want := c.Now(), physical.Add(time.Second); !got.Equal(want) {
+		t.Fatalf("c.Now() = %s; not %s", got, want)
+	}
+}
+
+// TestDBClockMonotonic tests that versions are strictly ordered even when the
+// configured clock is stuck.
+func TestDBClockMonotonic(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.Clock = fixedClock(time.Unix(100, 0))
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, b := db.now(), db.now()
+	if !b.After(a) {
+		t.Fatalf("db.now() = %s; not after %s", b, a)
+	}
+
+	k := []byte("key")
+	for i := 0; i < 20; i++ {
+		if err := db.Put(k, intToKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Force consolidation.
+	db.consolidate(rootPage)
+
+	want := intToKey(19)
+	if v, _ := db.Get(k); !bytes.Equal(v, want) {
+		t.Fatalf("db.Get(%q) = %q; not %q", k, v, want)
+	}
+}
+
+// TestDBClockFutureRead tests that reading at a time in the future doesn't move
+// the clock past the current time.
+func TestDBClockFutureRead(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("key")
+	if _, ok := db.GetAt(k, time.Now().Add(time.Hour)); ok {
+		t.Fatalf("db.GetAt(%q) should not be found", k)
+	}
+	if err := db.Put(k, k); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := db.GetAt(k, time.Now()); !ok || !bytes.Equal(v, k) {
+		t.Fatalf("db.GetAt(%q, time.Now()) = %q, %v; not %q", k, v, ok, k)
+	}
+}
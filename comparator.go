@@ -0,0 +1,30 @@
+package skeleton
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// ErrComparatorMismatch is returned when opening data that was written with a
+// comparator of a different name.
+var ErrComparatorMismatch = errors.New("the comparator doesn't match the one the data was written with")
+
+// Comparator defines the order of keys.
+type Comparator interface {
+	// Compare returns -1, 0 or +1 depending on whether a is less than, equal to
+	// or greater than b.
+	Compare(a, b []byte) int
+	// Name identifies the ordering. Data written with a comparator can only be
+	// opened with a comparator of the same name.
+	Name() string
+}
+
+// BytewiseComparator orders keys lexicographically by their bytes. This is the
+// default comparator.
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+func (bytewiseComparator) Name() string            { return "skeleton.BytewiseComparator" }
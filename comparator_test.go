@@ -0,0 +1,90 @@
+package skeleton
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+type caseInsensitiveComparator struct{}
+
+func (caseInsensitiveComparator) Compare(a, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}
+func (caseInsensitiveComparator) Name() string { return "test.CaseInsensitive" }
+
+// reverseComparator orders keys in reverse lexicographic order.
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+func (reverseComparator) Name() string            { return "test.Reverse" }
+
+func TestComparatorCaseInsensitive(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.Comparator = caseInsensitiveComparator{}
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put([]byte("Foo"), []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("FOO"), []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{2}
+	if v, _ := db.Get([]byte("foo")); !bytes.Equal(v, want) {
+		t.Fatalf("db.Get(foo) = %q; not %q", v, want)
+	}
+}
+
+// TestComparatorSplit tests that consolidation and splits respect the
+// comparator.
+func TestComparatorSplit(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 200
+
+	c := DefaultConfig
+	c.Comparator = reverseComparator{}
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Force consolidation and splitting
+	db.consolidate(rootPage)
+	db.split(rootPage)
+
+	root := db.getPage(rootPage).next.page
+	if root.key == nil {
+		t.Fatal("root page should be split")
+	}
+	for _, id := range []pageID{root.left, root.right} {
+		p := db.getPage(id).next.getPage()
+		for i := 1; i < len(p.keys); i++ {
+			if bytes.Compare(p.keyAt(i-1), p.keyAt(i)) <= 0 {
+				t.Fatalf("page %d: keys %q and %q out of order", id, p.keyAt(i-1), p.keyAt(i))
+			}
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if v, _ := db.Get(k); !bytes.Equal(v, k) {
+			t.Errorf("db.Get(%q) = %q; not %q", k, v, k)
+		}
+	}
+}
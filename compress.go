@@ -0,0 +1,95 @@
+package skeleton
+
+import (
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression is an algorithm that data pages are compressed with when they're
+// written to the page store.
+type Compression byte
+
+// Compression algorithms.
+const (
+	NoCompression Compression = iota
+	SnappyCompression
+	ZstdCompression
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case SnappyCompression:
+		return "snappy"
+	case ZstdCompression:
+		return "zstd"
+	}
+	return "unknown"
+}
+
+// zstd encoders and decoders are safe for concurrent use with EncodeAll and
+// DecodeAll, so they're shared and only created once they're needed.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func initZstd() {
+	zstdOnce.Do(func() {
+		var err error
+		if zstdEncoder, err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1)); err != nil {
+			panic(err)
+		}
+		if zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1)); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// compress returns b compressed with c, prefixed by the algorithm used. If
+// compression doesn't make b smaller, it's stored uncompressed.
+func compress(c Compression, b []byte) []byte {
+	var compressed []byte
+	switch c {
+	case SnappyCompression:
+		compressed = snappy.Encode(nil, b)
+	case ZstdCompression:
+		initZstd()
+		compressed = zstdEncoder.EncodeAll(b, nil)
+	}
+	if compressed == nil || len(compressed) >= len(b) {
+		return append([]byte{byte(NoCompression)}, b...)
+	}
+	return append([]byte{byte(c)}, compressed...)
+}
+
+// decompress returns the contents of a buffer written by compress.
+func decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, ErrCorrupt
+	}
+	c, b := Compression(b[0]), b[1:]
+	switch c {
+	case NoCompression:
+		return b, nil
+	case SnappyCompression:
+		out, err := snappy.Decode(nil, b)
+		if err != nil {
+			return nil, errors.Wrap(ErrCorrupt, err.Error())
+		}
+		return out, nil
+	case ZstdCompression:
+		initZstd()
+		out, err := zstdDecoder.DecodeAll(b, nil)
+		if err != nil {
+			return nil, errors.Wrap(ErrCorrupt, err.Error())
+		}
+		return out, nil
+	}
+	return nil, errors.Wrapf(ErrCorrupt, "unknown compression %d", c)
+}
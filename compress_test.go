@@ -0,0 +1,104 @@
+package skeleton
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+)
+
+func TestCompress(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(0)).Read(random)
+	repeated := bytes.Repeat([]byte(`{"name": "value"}`), 100)
+
+	for _, c := range []Compression{NoCompression, SnappyCompression, ZstdCompression} {
+		for _, b := range [][]byte{nil, random, repeated} {
+			compressed := compress(c, b)
+			if len(compressed) > len(b)+1 {
+				t.Errorf("%s: len(compress(%d bytes)) = %d; should never grow by more than a byte", c, len(b), len(compressed))
+			}
+			out, err := decompress(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, b) {
+				t.Errorf("%s: decompress(compress(%d bytes)) = %d bytes", c, len(b), len(out))
+			}
+		}
+		if c != NoCompression {
+			if compressed := compress(c, repeated); Compression(compressed[0]) != c || len(compressed) >= len(repeated)/2 {
+				t.Errorf("%s: compress(repeated) = %d bytes with %s", c, len(compressed), Compression(compressed[0]))
+			}
+			if compressed := compress(c, random); Compression(compressed[0]) != NoCompression {
+				t.Errorf("%s: compress(random) should be stored uncompressed", c)
+			}
+			if _, err := decompress([]byte{byte(c), 1, 2, 3}); errors.Cause(err) != ErrCorrupt {
+				t.Errorf("%s: decompress(garbage) = %+v; not ErrCorrupt", c, err)
+			}
+		}
+	}
+	if _, err := decompress([]byte{42}); errors.Cause(err) != ErrCorrupt {
+		t.Errorf("decompress(unknown) = %+v; not ErrCorrupt", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 200
+
+	for _, c := range []Compression{NoCompression, SnappyCompression, ZstdCompression} {
+		path, cleanup := tempPath(t)
+		defer cleanup()
+
+		config := DefaultConfig
+		config.MaxKeysPerNode = 10
+		config.MaxDeltaCount = 1
+		config.Path = path
+		config.Compression = c
+		db, err := NewDB(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := func(i int) []byte {
+			return []byte(fmt.Sprintf(`{"id": %d, "name": "name", "description": "description"}`, i))
+		}
+		for i := 0; i < count; i++ {
+			if err := db.Put(intToKey(i), value(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := db.Stats()
+		if stats.PageBytes == 0 || stats.CompressedPageBytes == 0 {
+			t.Fatalf("%s: db.Stats() = %+v; should count the pages written", c, stats)
+		}
+		if c == NoCompression && stats.CompressionRatio > 1 {
+			t.Errorf("%s: CompressionRatio = %f; should not be more than 1", c, stats.CompressionRatio)
+		}
+		if c != NoCompression && stats.CompressionRatio < 1.5 {
+			t.Errorf("%s: CompressionRatio = %f; should be at least 1.5", c, stats.CompressionRatio)
+		}
+
+		// Pages written with one algorithm can be read with another configured.
+		config.Compression = (c + 1) % (ZstdCompression + 1)
+		db, err = NewDB(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			if v, _ := db.Get(intToKey(i)); !bytes.Equal(v, value(i)) {
+				t.Errorf("%s: db.Get(%q) = %q; not %q", c, intToKey(i), v, value(i))
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -10,6 +10,8 @@ var DefaultConfig = Config{
 	MaxKeysPerNode: 100,
 	MaxDeltaCount:  10,
 	GCTime:         24 * time.Hour,
+
+	CheckpointInterval: time.Minute,
 }
 
 // Config holds configuration options for DB.
@@ -22,6 +24,54 @@ type Config struct {
 	MaxDeltaCount int
 	// GCTime is the amount of time until data is garbage collected.
 	GCTime time.Duration
+	// Clock provides the physical time of the hybrid logical clock that
+	// versions are timestamped with. If nil, the wall clock is used.
+	Clock Clock
+	// Comparator defines the order of keys. If nil, BytewiseComparator is used.
+	Comparator Comparator
+	// MergeOperator combines merge operands written by Merge with existing
+	// values. Merge returns an error if it's nil.
+	MergeOperator MergeOperator
+	// Path is the file that pages are persisted to. If it's empty, the DB is
+	// only kept in memory. Pages are read from the file as they're used, so the
+	// pages of a DB don't all have to fit in memory at once. A DB is opened as of
+	// the latest checkpoint in the file, so if the process crashes, every write
+	// committed since the last checkpoint, whether by CheckpointInterval or by
+	// Close, is lost.
+	Path string
+	// CheckpointInterval is how often a checkpoint of all pages is written to
+	// the file at Path in the background. A checkpoint holds the pages as of
+	// the time it starts, so commits don't wait for it. The file is compacted
+	// after a checkpoint once it's grown to twice its size since it was last
+	// compacted. Zero means checkpoints are only written by Close.
+	CheckpointInterval time.Duration
+	// MemoryBudget is the approximate number of bytes of pages to keep in
+	// memory. When it's exceeded, data pages that haven't been used recently
+	// are evicted to the file at Path. Zero means there is no budget.
+	MemoryBudget int64
+	// MemoryLimit is a hard limit on the approximate number of bytes of pages in
+	// memory. Once it's reached, writes, including deletes and restores, return
+	// ErrMemoryLimit. Zero means there is no limit.
+	MemoryLimit int64
+	// Compression is the algorithm that data pages are compressed with when
+	// they're written to the file at Path. Pages are decompressed when they're
+	// read back into memory, and pages written with another algorithm can still
+	// be read.
+	Compression Compression
+	// Encryption provides the keys that the file at Path is encrypted with
+	// using AES-GCM. If it's nil, the file isn't encrypted, and a DB whose file
+	// is encrypted can't be opened. There is no write-ahead log: pages and
+	// checkpoints are only ever written to the file at Path, so it's the only
+	// thing encrypted.
+	Encryption KeyProvider
+}
+
+// comparator returns the configured comparator or the default.
+func (c Config) comparator() Comparator {
+	if c.Comparator == nil {
+		return BytewiseComparator
+	}
+	return c.Comparator
 }
 
 // Verify returns an error if an invariant is violated.
@@ -35,5 +85,20 @@ func (c Config) Verify() error {
 	if c.GCTime < 0 {
 		return errors.New("GCTime must not be negative")
 	}
+	if c.MemoryBudget < 0 {
+		return errors.New("MemoryBudget must not be negative")
+	}
+	if c.MemoryLimit < 0 {
+		return errors.New("MemoryLimit must not be negative")
+	}
+	if c.CheckpointInterval < 0 {
+		return errors.New("CheckpointInterval must not be negative")
+	}
+	if c.Compression > ZstdCompression {
+		return errors.New("unknown Compression")
+	}
+	if c.MemoryBudget > 0 && c.Path == "" {
+		return errors.New("MemoryBudget requires a Path to evict pages to")
+	}
 	return nil
 }
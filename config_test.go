@@ -51,6 +51,22 @@ func TestConfig(t *testing.T) {
 			},
 			err: "GCTime",
 		},
+		{
+			c: Config{
+				MaxKeysPerNode: 1,
+				MaxDeltaCount:  1,
+				Compression:    ZstdCompression + 1,
+			},
+			err: "Compression",
+		},
+		{
+			c: Config{
+				MaxKeysPerNode:     1,
+				MaxDeltaCount:      1,
+				CheckpointInterval: -1,
+			},
+			err: "CheckpointInterval",
+		},
 	}
 	for i, tc := range testCases {
 		if err := tc.c.Verify(); !strings.Contains(fmt.Sprintf("%s", err), tc.err) {
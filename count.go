@@ -0,0 +1,76 @@
+package skeleton
+
+import (
+	"log"
+	"time"
+)
+
+// Count returns the number of keys in [start, end) of the database at the
+// specified time. A nil start or end leaves that side of the range unbounded,
+// and the zero time counts the latest values.
+func (db *DB) Count(start, end []byte, at time.Time) int {
+	return db.ns.Count(start, end, at)
+}
+
+// Count returns the number of keys in [start, end) of the namespace at the
+// specified time. A nil start or end leaves that side of the range unbounded,
+// and the zero time counts the latest values.
+func (ns *Namespace) Count(start, end []byte, at time.Time) int {
+	at = ns.db.snapshot(at)
+	return ns.db.count(ns, start, end, at)
+}
+
+// count uses the key counts of data pages that have no deltas and are entirely
+// in the range, and only reads the values of the other pages. If a page can't
+// be read from the page store, the error is logged and the keys counted so far
+// are returned.
+func (db *DB) count(ns *Namespace, start, end []byte, at time.Time) int {
+	n := 0
+	for k := start; ; {
+		var d *delta
+		var high []byte
+		var err error
+		if k == nil {
+			_, d, high, err = db.firstLeaf(ns)
+		} else {
+			_, d, high, err = db.findLeaf(ns, k)
+		}
+		if err != nil {
+			log.Printf("count: %+v", err)
+			return n
+		}
+		if p := d.page; p != nil && p.within(start, end) && (isZeroTime(at) || !at.Before(p.newest)) {
+			n += p.live
+		} else {
+			n += len(db.leafEntries(ns, d, nil, k, end, at))
+		}
+		if high == nil || end != nil && ns.comparator().Compare(high, end) >= 0 {
+			return n
+		}
+		k = high
+	}
+}
+
+// updateCounts updates the key counts of a consolidated data page.
+func (p *page) updateCounts() {
+	p.live = 0
+	p.newest = time.Time{}
+	for _, k := range p.keys {
+		latest := k.values[0]
+		if !latest.tombstone {
+			p.live++
+		}
+		if latest.time.After(p.newest) {
+			p.newest = latest.time
+		}
+	}
+}
+
+// within returns whether all keys of the page are in [start, end).
+func (p *page) within(start, end []byte) bool {
+	if len(p.keys) == 0 {
+		return true
+	}
+	first, last := p.keyAt(0), p.keyAt(len(p.keys)-1)
+	return (start == nil || p.ns.comparator().Compare(start, first) <= 0) && (end == nil || p.ns.comparator().Compare(last, end) < 0)
+}
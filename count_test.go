@@ -0,0 +1,58 @@
+package skeleton
+
+import (
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestCount(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	db := splitDB(t, count)
+	defer db.Close()
+
+	before := db.now()
+	if n := db.Count(nil, nil, zeroTime); n != count {
+		t.Fatalf("db.Count(nil, nil) = %d; not %d", n, count)
+	}
+	// key2, key20-key29, key3, key30-key39 and key4, key40-key49.
+	if n := db.Count([]byte("key2"), []byte("key5"), zeroTime); n != 33 {
+		t.Fatalf("db.Count(key2, key5) = %d; not 33", n)
+	}
+
+	db.Delete([]byte("key42"))
+	db.Put([]byte("key42a"), []byte("key42a"))
+	// Keys with nil values are counted like any other key.
+	db.Delete([]byte("key43"))
+	db.Put([]byte("key43a"), nil)
+	check := func() {
+		if n := db.Count(nil, nil, zeroTime); n != count {
+			t.Errorf("db.Count(nil, nil) = %d; not %d", n, count)
+		}
+		if n := db.Count([]byte("key4"), []byte("key5"), zeroTime); n != 11 {
+			t.Errorf("db.Count(key4, key5) = %d; not 11", n)
+		}
+		if n := db.Count([]byte("key4"), []byte("key5"), before); n != 11 {
+			t.Errorf("db.Count(key4, key5, before) = %d; not 11", n)
+		}
+		if n := db.Count([]byte("key42"), []byte("key43"), before); n != 1 {
+			t.Errorf("db.Count(key42, key43, before) = %d; not 1", n)
+		}
+	}
+	check()
+
+	// Consolidated pages are counted without reading their values.
+	consolidateAll(db)
+	check()
+	for i := range *db.pages {
+		p := db.getPage(pageID(i + 1)).next
+		if p == nil || p.page == nil || p.page.key != nil {
+			continue
+		}
+		if got := len(db.leafEntries(p.page.ns, p, nil, nil, nil, zeroTime)); got != p.page.live {
+			t.Errorf("page %d: live = %d; not %d", i+1, p.page.live, got)
+		}
+	}
+}
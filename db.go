@@ -1,25 +1,63 @@
 package skeleton
 
 import (
-	"bytes"
 	"log"
 	"os"
+	"runtime"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
+
+	"github.com/pkg/errors"
 )
 
 const (
 	rootPage = pageID(1)
 )
 
+// zeroTime is the time that reads the latest versions.
 var zeroTime = time.Unix(0, 0)
 
+// isZeroTime returns whether t is unset, either as the zero time.Time or as
+// zeroTime.
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(zeroTime)
+}
+
 // DB is a skeletondb instance.
 type DB struct {
 	pages  *[]*delta
 	closed chan struct{}
-	config Config
+	// workers is done when the worker loop has exited.
+	workers sync.WaitGroup
+	config  Config
+	// store holds the persisted pages, or is nil if the DB is only in memory.
+	store *pageStore
+	// storeLock is read locked while pages are read from the store outside of
+	// the worker loop, and locked while the store is replaced by compaction.
+	storeLock sync.RWMutex
+	// usage is the approximate number of bytes used by pages in memory.
+	usage usage
+	// clockHand is the next page ID to consider for eviction.
+	clockHand pageID
+	// ns is the default namespace.
+	ns *Namespace
+	// namespaces holds a *map[string]*Namespace of the named namespaces.
+	namespaces unsafe.Pointer
+	// clock is the hybrid logical clock that versions are timestamped with.
+	clock *hybridClock
+	// stampGen and stamps count the writes between taking their timestamp and
+	// becoming visible, so snapshots can wait for the ones older than them.
+	// Writes are counted in stamps[stampGen&1]. A snapshot moves stampGen on
+	// and waits for the count of the previous generation to drain.
+	stampGen int64
+	stamps   [2]int64
+	// snapshotMu is held by the snapshot moving stampGen on, and snapshotted
+	// is the time in nanoseconds at or before which every write is visible.
+	snapshotMu  sync.Mutex
+	snapshotted int64
 
 	// largetPageID is automatically incremented when a new page is created.
 	largestPageID int64
@@ -28,6 +66,7 @@ type DB struct {
 	// Queues
 	splitQueue       chan pageID
 	consolidateQueue chan pageID
+	evictQueue       chan struct{}
 }
 
 type unsafeDB struct {
@@ -43,21 +82,52 @@ func NewDB(c *Config) (*DB, error) {
 	if err := c.Verify(); err != nil {
 		return nil, err
 	}
+	physical := c.Clock
+	if physical == nil {
+		physical = wallClock{}
+	}
 	db := &DB{
 		splitQueue:       make(chan pageID, 10),
 		consolidateQueue: make(chan pageID, 10),
+		evictQueue:       make(chan struct{}, 1),
 		closed:           make(chan struct{}),
-		pages: &[]*delta{
-			{
-				next: &delta{
-					page: &page{id: 1},
-				},
+		config:           *c,
+		clock:            newHybridClock(physical),
+		largestPageID:    1,
+		pageIDPool:       make(chan pageID, 10),
+	}
+	db.ns = &Namespace{
+		db:     db,
+		root:   rootPage,
+		config: *c,
+	}
+	db.ns.setComparator(c.comparator())
+	db.pages = &[]*delta{
+		{
+			next: &delta{
+				page: &page{id: rootPage, ns: db.ns},
 			},
 		},
-		config:        *c,
-		largestPageID: 1,
-		pageIDPool:    make(chan pageID, 10),
 	}
+	db.usage = chainUsage(db.getPage(rootPage).next)
+	if c.Path != "" {
+		store, err := openPageStore(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		store.compression = c.Compression
+		store.keys = c.Encryption
+		db.store = store
+		if _, _, err := store.seal(0, nil); err != nil {
+			store.close()
+			return nil, errors.Wrap(err, "checking the encryption key")
+		}
+		if err := db.load(); err != nil {
+			store.close()
+			return nil, err
+		}
+	}
+	db.workers.Add(1)
 	go db.workerLoop()
 	return db, nil
 }
@@ -91,18 +161,40 @@ func (db *DB) nextPageID() pageID {
 	return id
 }
 
-// Close closes the database and all workers.
-func (db *DB) Close() {
+// Close closes the database and all workers. If the database is persisted,
+// a checkpoint of all pages is written to the page store first, and its error
+// is returned. The page store is then compacted if it needs it, which also
+// drops the records of keys that have been rotated out. Writes of transactions
+// that haven't been committed are not persisted.
+func (db *DB) Close() error {
 	close(db.closed)
+	db.workers.Wait()
+	if db.store == nil {
+		return nil
+	}
+	if err := db.checkpoint(); err != nil {
+		db.store.close()
+		return err
+	}
+	if db.store.needsCompaction() {
+		if err := db.compact(); err != nil {
+			db.store.close()
+			return err
+		}
+	}
+	return db.store.close()
 }
 
 // Key represents a single key with potentially multiple values. A key with no
-// values represents a read intent.
+// values represents a read intent. A key with an end represents a range
+// tombstone covering [key, end).
 type key struct {
 	key    []byte
+	end    []byte
 	txn    *Txn
 	values []value
-	read   bool // read is whether this key is a get intent
+	read   bool  // read is whether this key is a get intent
+	seq    int64 // seq is the position of the write within txn
 }
 
 func (k key) clone() key {
@@ -110,34 +202,111 @@ func (k key) clone() key {
 	return k
 }
 
-// byKey implements sort.Interface for []*key first based on key and then by
-// the timestamp.
-type byKey []*key
+// covers returns whether b is the key, or is within the range of a range
+// tombstone.
+func (k *key) covers(cmp Comparator, b []byte) bool {
+	if k.end == nil {
+		return cmp.Compare(k.key, b) == 0
+	}
+	return cmp.Compare(k.key, b) <= 0 && cmp.Compare(b, k.end) < 0
+}
 
-func (a byKey) Len() int      { return len(a) }
-func (a byKey) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a byKey) Less(i, j int) bool {
-	compare := bytes.Compare(a[i].key, a[j].key)
-	if compare == 0 {
-		return a[i].values[0].time.Before(a[j].values[0].time)
+// overlaps returns whether k and b cover any of the same keys.
+func (k *key) overlaps(cmp Comparator, b *key) bool {
+	if k.end == nil {
+		return b.covers(cmp, k.key)
 	}
-	return compare < 0
+	if b.end == nil {
+		return k.covers(cmp, b.key)
+	}
+	return cmp.Compare(k.key, b.end) < 0 && cmp.Compare(b.key, k.end) < 0
 }
 
-func (k *key) getAt(txn *Txn, at time.Time) ([]byte, bool) {
-	if k.txn != nil && txn != txn && k.txn.status != StatusCommitted {
-		return nil, false
+// committedBy returns whether the key was committed with every version at or
+// before the time. Keys committed at any time count for the zero time.
+func (k *key) committedBy(at time.Time) bool {
+	if !k.committed() {
+		return false
 	}
-	for _, v := range k.values {
-		if at != zeroTime && at.Before(v.time) {
-			continue
+	if !isZeroTime(at) {
+		for _, v := range k.values {
+			if k.versionTime(v).After(at) {
+				return false
+			}
 		}
-		if v.tombstone {
-			return nil, true
+	}
+	return true
+}
+
+// committed returns whether the key is visible to everyone.
+func (k *key) committed() bool {
+	return (k.txn == nil || k.txn.Status() == StatusCommitted) && !k.rolledBack()
+}
+
+// visibleTo returns whether the key is visible to the transaction. Keys are
+// visible if they are committed or written by the transaction itself.
+func (k *key) visibleTo(txn *Txn) bool {
+	if k.txn != nil && k.txn != txn && k.txn.Status() != StatusCommitted {
+		return false
+	}
+	return !k.rolledBack()
+}
+
+// versionTime returns the time of v, a version of the key. The versions written
+// by a committed transaction take its commit timestamp.
+func (k *key) versionTime(v value) time.Time {
+	if k.txn != nil {
+		if t := k.txn.CommitTime(); !t.IsZero() {
+			return t
 		}
-		return v.value, true
 	}
-	return nil, false
+	return v.time
+}
+
+// stamp returns the key with the versions written by a committed transaction
+// set to its commit timestamp, and without the transaction.
+func (k *key) stamp() *key {
+	if k.txn == nil {
+		return k
+	}
+	stamped := k.clone()
+	for i, v := range stamped.values {
+		stamped.values[i].time = k.versionTime(v)
+	}
+	stamped.txn = nil
+	return &stamped
+}
+
+// rolledBack returns whether the key was written after a savepoint that the
+// transaction has since been rolled back to.
+func (k *key) rolledBack() bool {
+	return k.txn != nil && k.txn.rolledBack(k.seq)
+}
+
+// byKey implements sort.Interface for []*key first based on key, then by the
+// timestamp and then by the order of writes within a transaction.
+type byKey struct {
+	keys []*key
+	cmp  Comparator
+}
+
+func (a byKey) Len() int      { return len(a.keys) }
+func (a byKey) Swap(i, j int) { a.keys[i], a.keys[j] = a.keys[j], a.keys[i] }
+func (a byKey) Less(i, j int) bool {
+	compare := a.cmp.Compare(a.keys[i].key, a.keys[j].key)
+	if compare == 0 {
+		ti, tj := a.keys[i].values[0].time, a.keys[j].values[0].time
+		if ti.Equal(tj) {
+			return a.keys[i].seq < a.keys[j].seq
+		}
+		return ti.Before(tj)
+	}
+	return compare < 0
+}
+
+// isMerge returns whether the key is a merge operand.
+func (k *key) isMerge() bool {
+	return len(k.values) > 0 && k.values[0].merge
 }
 
 // Value represents a value and the previous versions.
@@ -145,113 +314,227 @@ type value struct {
 	value     []byte
 	time      time.Time
 	tombstone bool
+	merge     bool // merge is whether value is an operand for MergeOperator
+}
+
+// lookup resolves the value of a key from its versions, newest first.
+type lookup struct {
+	// operands holds the merge operands newer than value with the times they
+	// were written at.
+	operands []value
+	value    []byte
+	// found is whether any version has been found.
+	found bool
+	// time is the time of the newest version found.
+	time time.Time
+	// done is whether a full value or tombstone has been found.
+	done bool
+	// tombstone is whether the full version found is a tombstone.
+	tombstone bool
+}
+
+// add adds the versions of k visible at the specified time to the lookup.
+func (l *lookup) add(k *key, at time.Time) {
+	for _, v := range k.values {
+		if l.done {
+			return
+		}
+		t := k.versionTime(v)
+		if !isZeroTime(at) && at.Before(t) {
+			continue
+		}
+		if !l.found || t.After(l.time) {
+			l.time = t
+		}
+		l.found = true
+		if v.merge {
+			v.time = t
+			l.operands = append(l.operands, v)
+			continue
+		}
+		if !v.tombstone {
+			l.value = v.value
+		}
+		l.tombstone = v.tombstone
+		l.done = true
+	}
+}
+
+// exists returns whether the lookup found a value. Unlike resolve, it doesn't
+// count a tombstone that hasn't been merged into since, so it tells a deleted
+// key apart from one with a nil value.
+func (l *lookup) exists() bool {
+	return l.found && (!l.tombstone || len(l.operands) > 0)
+}
+
+// resolve returns the value found by the lookup after applying any merge
+// operands.
+func (db *DB) resolve(k []byte, l *lookup) ([]byte, bool) {
+	if !l.found {
+		return nil, false
+	}
+	v := l.value
+	// Operands are found in the order of the delta chain, but are applied in
+	// timestamp order like consolidation does.
+	sort.Stable(byTime(l.operands))
+	for i := len(l.operands) - 1; i >= 0; i-- {
+		v = db.config.MergeOperator.Merge(k, v, l.operands[i].value)
+	}
+	return v, true
 }
 
 type pageID int64
 
 type page struct {
-	id    pageID
-	key   []byte
-	keys  []*key
-	left  pageID
-	right pageID
+	id   pageID
+	ns   *Namespace
+	key  []byte
+	keys []*key
+	// prefix is shared by every key of a consolidated data page, and is removed
+	// from the keys in keys. Use keyAt for a whole key.
+	prefix []byte
+	left   pageID
+	right  pageID
+	// high is the exclusive upper bound of a data page, or nil if it has none.
+	high []byte
+	// prev and next are the IDs of the sibling data pages, or 0 if there are
+	// none. Pages split off of prev since it was set are between it and this
+	// page.
+	prev, next pageID
+
+	// offset is where the page is in the page store, or 0 if this version of
+	// the page hasn't been written to it. A stub page only has its offset set,
+	// and has to be read from the page store before use.
+	offset int64
+	stub   bool
+	// keyID is the ID of the key the page is encrypted with in the page store,
+	// or 0 if it isn't encrypted.
+	keyID uint32
+
+	// live is the number of keys with a value, and newest is the time of the
+	// newest version. They're computed by updateCounts.
+	live   int
+	newest time.Time
 }
 
-// Get gets a value from the database.
+// Get gets a value from the database. If the page holding the key can't be
+// read from the page store because it's corrupt, the error is logged and Get
+// returns nil, false, as if the key had never been written. Other reads that
+// can't return an error, like GetMany, Count and History, also log the error
+// and skip the keys on the page. Verify reports such pages, and Iterator.Err
+// returns the error for scans.
 func (db *DB) Get(key []byte) ([]byte, bool) {
-	return db.getAt(nil, key, zeroTime)
+	return db.ns.Get(key)
 }
 
-// GetAt gets a value from the database at the specified time.
+// GetAt gets a value from the database at the specified time. A time in the
+// future reads the current time.
 func (db *DB) GetAt(key []byte, at time.Time) ([]byte, bool) {
-	return db.getAt(nil, key, at)
+	return db.ns.GetAt(key, at)
 }
 
-func (db *DB) getAt(txn *Txn, k []byte, at time.Time) ([]byte, bool) {
-	id := rootPage
-	page := db.getPage(id)
-	delta := page.next
-	deltaCount := 0
-	defer func() {
-		// If there is a pending transaction, abort the current transaction.
-		if txn != nil {
-			if t := page.hasPendingTxn(k); t != nil && t != txn {
-				if err := txn.Close(); err != nil {
-					panic("txn should not already be closed")
-				}
-			} else {
-				if err := db.putKey(&key{
-					key:  k,
-					txn:  txn,
-					read: true,
-				}); err != nil {
-					panic("error adding read intent")
-				}
-			}
-		}
+// getAt is get for reads that can't return an error. If a page can't be read
+// from the page store, the error is logged and the key isn't found. Verify
+// reports the page.
+func (db *DB) getAt(ns *Namespace, txn *Txn, k []byte, at time.Time) ([]byte, bool) {
+	l, err := db.get(ns, txn, k, at)
+	if err != nil {
+		log.Printf("get %q: %+v", k, err)
+		return nil, false
+	}
+	return db.resolve(k, &l)
+}
 
-		// Check if the depth is too high, and if so, queue for consolidation.
-		if deltaCount > db.config.MaxDeltaCount {
-			db.consolidateQueue <- id
+// get looks up k in the namespace.
+func (db *DB) get(ns *Namespace, txn *Txn, k []byte, at time.Time) (lookup, error) {
+	id, d, _, err := db.findLeaf(ns, k)
+	if err != nil {
+		return lookup{}, err
+	}
+	l, deltaCount := db.lookupChain(ns, d, txn, k, at)
+	if txn != nil {
+		if err := db.addReadIntent(ns, txn, k); err != nil {
+			return lookup{}, err
 		}
-	}()
-	for delta != nil {
+	}
+
+	// Check if the depth is too high, and if so, queue for consolidation.
+	if deltaCount > ns.config.MaxDeltaCount {
+		db.consolidateQueue <- id
+	}
+	return l, nil
+}
+
+// addReadIntent adds a read intent on k for the transaction. If there is a
+// pending transaction on k, the transaction is aborted instead. The intent is
+// added to the data page that holds k when it's added, which may have been
+// split since k was read. Transactions that aren't pending, including prepared
+// ones, don't add intents. An error is only returned if the page can't be read
+// from the page store.
+func (db *DB) addReadIntent(ns *Namespace, txn *Txn, k []byte) error {
+	if txn.Status() != StatusPending {
+		return nil
+	}
+	if err := db.putKey(ns, &key{
+		key:  k,
+		txn:  txn,
+		read: true,
+	}); err != nil && err != ErrTxnConflict {
+		return err
+	}
+	return nil
+}
+
+// lookupChain walks the delta chain of a data page for the versions of k
+// visible to txn at the specified time. It returns the lookup and the number of
+// deltas that were walked.
+func (db *DB) lookupChain(ns *Namespace, delta *delta, txn *Txn, k []byte, at time.Time) (lookup, int) {
+	var l lookup
+	deltaCount := 0
+	for delta != nil && !l.done {
 		if (delta.key == nil) == (delta.page == nil) {
 			panic("invariant: exactly one of delta.key, delta.page must be set")
 		}
 
 		if delta.page != nil { // Check page for match.
-			dPage := delta.page
-			if dPage.key != nil { // Index node
-				if bytes.Compare(dPage.key, k) <= 0 {
-					id = dPage.right
-				} else {
-					id = dPage.left
-				}
-				page = db.getPage(id)
-				delta = page.next
-			} else { // Data node
-				for _, entry := range dPage.keys {
-					if bytes.Equal(entry.key, k) {
-						return entry.getAt(txn, at)
-					}
-				}
-				break
-			}
-		} else if delta.key != nil { // Check delta for match.
-			deltaCount++
-
-			// Skip uncommitted keys.
-			t := delta.key.txn
-			if t != nil && t != txn && t.status != StatusCommitted {
-				delta = delta.next
-				continue
-			}
-			if bytes.Equal(k, delta.key.key) {
-				// If the time isn't found in the delta, look at older data.
-				if v, ok := delta.key.getAt(txn, at); ok {
-					return v, true
-				}
+			if entry := delta.page.find(ns.comparator(), k); entry != nil {
+				l.add(entry, at)
 			}
+			break
+		}
+
+		// Check delta for match.
+		deltaCount++
+
+		// Skip uncommitted and rolled back keys.
+		if !delta.key.visibleTo(txn) {
 			delta = delta.next
+			continue
+		}
+		if delta.key.covers(ns.comparator(), k) {
+			// If the time isn't found in the delta, look at older data.
+			l.add(delta.key, at)
 		}
+		delta = delta.next
 	}
-	return nil, false
+	return l, deltaCount
 }
 
 // Put writes a value into the database.
 func (db *DB) Put(k, v []byte) error {
-	return db.put(nil, k, v)
+	return db.ns.Put(k, v)
 }
 
-func (db *DB) put(txn *Txn, k, v []byte) error {
-	return db.putKey(&key{
+func (db *DB) put(ns *Namespace, txn *Txn, k, v []byte) error {
+	if err := db.checkMemoryLimit(); err != nil {
+		return err
+	}
+	return db.putKey(ns, &key{
 		key: k,
 		txn: txn,
 		values: []value{
 			{
 				value: v,
-				time:  time.Now(),
 			},
 		},
 	})
@@ -259,60 +542,219 @@ func (db *DB) put(txn *Txn, k, v []byte) error {
 
 // Delete removes a value from the database.
 func (db *DB) Delete(k []byte) error {
-	return db.delete(nil, k)
+	return db.ns.Delete(k)
 }
 
-func (db *DB) delete(txn *Txn, k []byte) error {
-	return db.putKey(&key{
+func (db *DB) delete(ns *Namespace, txn *Txn, k []byte) error {
+	if err := db.checkMemoryLimit(); err != nil {
+		return err
+	}
+	return db.putKey(ns, &key{
 		key: k,
 		txn: txn,
 		values: []value{
 			{
 				tombstone: true,
-				time:      time.Now(),
 			},
 		},
 	})
 }
 
-func (db *DB) putKey(key *key) error {
+// putKey adds the key to the namespace, with its versions timestamped when
+// they're added.
+func (db *DB) putKey(ns *Namespace, key *key) error {
+	if key.txn != nil {
+		key.seq = key.txn.nextSeq()
+	}
+	_, err := db.insert(ns, key, key.key, true)
+	return err
+}
+
+// insert adds the key as a delta on the data page that k belongs in, and
+// returns the upper bound of that page. If stamp is set, the versions of key
+// are timestamped right before each attempt to add it, so every delta is newer
+// than the deltas below it.
+func (db *DB) insert(ns *Namespace, key *key, k []byte, stamp bool) ([]byte, error) {
 	for {
-		id := rootPage
-		page := db.getPage(id)
-		d := page.next
-
-		// Find the matching page.  We only have to check if the first page is nil
-		// since index nodes won't have any deltas on top of them.
-		for d.page != nil {
-			if d.page.key == nil {
-				break
+		id, d, high, err := db.findLeaf(ns, k)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.checkConflict(ns, d, key); err != nil {
+			return nil, err
+		}
+
+		var gen int64
+		if stamp {
+			gen = db.startStamp()
+			now := db.now()
+			for i := range key.values {
+				key.values[i].time = now
 			}
+		}
+
+		insert := delta{
+			key:  key,
+			next: d,
+		}
+		saved := db.savePageNext(id, d, &insert)
+		if stamp {
+			db.endStamp(gen)
+		}
+		if saved {
+			db.addUsage(insert.usage())
+			return high, nil
+		}
 
-			if bytes.Compare(d.page.key, key.key) <= 0 {
+	}
+}
+
+// snapshot makes reads at the time repeatable, and returns the time to read at,
+// which is clamped to the current time if it's in the future. Later writes and
+// commits are timestamped after it, and writes already timestamped at or
+// before it are waited for. Writers are never blocked, and reads at a time that
+// an earlier snapshot already covers don't wait at all.
+func (db *DB) snapshot(at time.Time) time.Time {
+	if isZeroTime(at) {
+		return at
+	}
+	if now := db.now(); at.After(now) {
+		at = now
+	}
+	if at.UnixNano() <= atomic.LoadInt64(&db.snapshotted) {
+		return at
+	}
+
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+	if at.UnixNano() <= atomic.LoadInt64(&db.snapshotted) {
+		return at
+	}
+	// Every write timestamped before now was counted in the current
+	// generation before it took its timestamp.
+	now := db.now()
+	gen := atomic.AddInt64(&db.stampGen, 1) - 1
+	for atomic.LoadInt64(&db.stamps[gen&1]) != 0 {
+		runtime.Gosched()
+	}
+	atomic.StoreInt64(&db.snapshotted, now.UnixNano())
+	return at
+}
+
+// startStamp counts a write that's about to take its timestamp, and returns
+// the generation to pass to endStamp once the write is visible.
+func (db *DB) startStamp() int64 {
+	for {
+		gen := atomic.LoadInt64(&db.stampGen)
+		atomic.AddInt64(&db.stamps[gen&1], 1)
+		if atomic.LoadInt64(&db.stampGen) == gen {
+			return gen
+		}
+		atomic.AddInt64(&db.stamps[gen&1], -1)
+	}
+}
+
+// endStamp stops counting a write started by startStamp.
+func (db *DB) endStamp(gen int64) {
+	atomic.AddInt64(&db.stamps[gen&1], -1)
+}
+
+// checkConflict returns ErrTxnConflict and aborts the transaction of key if the
+// delta chain has a pending transaction on the same keys.
+func (db *DB) checkConflict(ns *Namespace, d *delta, key *key) error {
+	if txn := d.pendingTxn(ns.comparator(), key); txn != nil && txn != key.txn {
+		if key.txn != nil {
+			key.txn.abort()
+		}
+		return ErrTxnConflict
+	}
+	return nil
+}
+
+// findLeaf returns the ID and delta chain of the data page in the namespace
+// that k belongs in, as well as the exclusive upper bound of the page. A nil
+// upper bound means the page has no upper bound.
+func (db *DB) findLeaf(ns *Namespace, k []byte) (pageID, *delta, []byte, error) {
+	id := ns.root
+	d, err := db.getChain(id)
+	for err == nil {
+		// Index nodes won't have any deltas on top of them.
+		if d.page != nil && d.page.key != nil {
+			if ns.comparator().Compare(d.page.key, k) <= 0 {
 				id = d.page.right
 			} else {
 				id = d.page.left
 			}
-			d = db.getPage(id).next
+			d, err = db.getChain(id)
+			continue
 		}
 
-		// Check for pending transactions on the same key.
-		if txn := d.hasPendingTxn(key.key); txn != nil && txn != key.txn {
-			if err := key.txn.Close(); err != nil {
-				return err
-			}
-			return ErrTxnConflict
+		// If the data page was split after its parent was read, k may have
+		// moved onto a right sibling.
+		p := d.getPage()
+		if p.high == nil || ns.comparator().Compare(k, p.high) < 0 {
+			return id, d, p.high, nil
 		}
+		id = p.next
+		d, err = db.getChain(id)
+	}
+	return 0, nil, nil, err
+}
 
-		insert := delta{
-			key:  key,
-			next: d,
+// firstLeaf is like findLeaf, but returns the first data page in the
+// namespace.
+func (db *DB) firstLeaf(ns *Namespace) (pageID, *delta, []byte, error) {
+	id, d, err := db.descend(ns.root, false)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return id, d, d.getPage().high, nil
+}
+
+// descend returns the leftmost or rightmost data page under the page.
+func (db *DB) descend(id pageID, rightmost bool) (pageID, *delta, error) {
+	d, err := db.getChain(id)
+	for err == nil && d.page != nil && d.page.key != nil {
+		if rightmost {
+			id = d.page.right
+		} else {
+			id = d.page.left
 		}
-		if db.savePageNext(id, d, &insert) {
-			break
+		d, err = db.getChain(id)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, d, nil
+}
+
+// getChain returns the delta chain of the page. If the page is only in the
+// page store, it's read into memory first, which can fail if the page store is
+// corrupt.
+func (db *DB) getChain(id pageID) (*delta, error) {
+	slot := db.getPage(id)
+	if db.config.MemoryBudget > 0 {
+		atomic.StoreInt32(&slot.referenced, 1)
+	}
+	for {
+		d := slot.next
+		if d == nil || d.page == nil || !d.page.stub {
+			return d, nil
+		}
+		db.storeLock.RLock()
+		p, err := db.store.readPage(d.page.offset, d.page.ns)
+		if err != nil {
+			db.storeLock.RUnlock()
+			return nil, errors.Wrapf(err, "reading page %d", id)
+		}
+		loaded := &delta{page: p}
+		saved := db.savePageNext(id, d, loaded)
+		db.storeLock.RUnlock()
+		if saved {
+			db.addUsage(chainUsage(loaded))
+			return loaded, nil
 		}
 	}
-	return nil
 }
 
 func (db *DB) getPage(id pageID) *delta {
@@ -1,15 +1,15 @@
 package skeleton
 
-import (
-	"bytes"
-	"unsafe"
-)
+import "unsafe"
 
 // delta represents a single change to be applied to a page.
 type delta struct {
 	key  *key
 	page *page
 	next *delta
+	// referenced is set on the mapping table entry of a page when the page is
+	// used, and cleared by eviction.
+	referenced int32
 }
 
 type unsafeDelta struct {
@@ -36,9 +36,20 @@ func (d *delta) deltaCount() int {
 
 // hasPendingTxn returns whether the delta or it's children has a pending
 // transaction on the specified key.
-func (d *delta) hasPendingTxn(k []byte) *Txn {
+func (d *delta) hasPendingTxn(cmp Comparator, k []byte) *Txn {
+	return d.pendingTxn(cmp, &key{key: k})
+}
+
+// pendingTxn returns the pending transaction, if any, of the delta or it's
+// children that overlaps with the key or range of k.
+func (d *delta) pendingTxn(cmp Comparator, k *key) *Txn {
 	for ; d != nil; d = d.next {
-		if d.key == nil || !bytes.Equal(d.key.key, k) {
+		if d.key == nil || !d.key.overlaps(cmp, k) {
+			continue
+		}
+		// Merge operands are applied in timestamp order, so they don't conflict
+		// with each other.
+		if d.key.isMerge() && k.isMerge() {
 			continue
 		}
 		if d.isPending() {
@@ -48,9 +59,10 @@ func (d *delta) hasPendingTxn(k []byte) *Txn {
 	return nil
 }
 
-// isPending returns whether the current delta is part of a pending transaction.
+// isPending returns whether the current delta is part of a pending or prepared
+// transaction and hasn't been rolled back.
 func (d delta) isPending() bool {
-	return d.key != nil && d.key.txn != nil && d.key.txn.status == StatusPending
+	return d.key != nil && d.key.txn != nil && d.key.txn.status.active() && !d.key.rolledBack()
 }
 
 // getPage walks the delta and returns the page from the last element.
@@ -62,3 +74,17 @@ func (d *delta) getPage() *page {
 	}
 	return d.page
 }
+
+// appendDelta appends a copy of d to the list from head to tail, and returns
+// the new head and tail.
+func appendDelta(head, tail, d *delta) (*delta, *delta) {
+	d2 := d.clone()
+	d2.next = nil
+	if tail != nil {
+		tail.next = d2
+	}
+	if head == nil {
+		head = d2
+	}
+	return head, d2
+}
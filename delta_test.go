@@ -158,7 +158,7 @@ func TestDeltaHasPendingTxn(t *testing.T) {
 	}
 
 	for i, tc := range testCases {
-		if out := tc.d.hasPendingTxn(k); (out != nil) != tc.expected {
+		if out := tc.d.hasPendingTxn(BytewiseComparator, k); (out != nil) != tc.expected {
 			t.Errorf("%d: %v.hasPendingTxn() = %v; expected %v", i, tc.d, out, tc.expected)
 		}
 	}
@@ -0,0 +1,166 @@
+package skeleton
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEncryptionKey is returned when persisted data can't be decrypted, because
+// no KeyProvider was configured or it doesn't have the right key.
+var ErrEncryptionKey = errors.New("missing or wrong encryption key")
+
+// KeyProvider supplies the keys that the page store is encrypted with. Keys
+// must be 16, 24 or 32 bytes long to use AES-128, AES-192 or AES-256, and the
+// key with an ID must never change. Since the current key is requested for
+// every record written, providers backed by a remote service should cache it.
+type KeyProvider interface {
+	// CurrentKey returns the non-zero ID of the key that new records are
+	// encrypted with, and the key.
+	CurrentKey() (uint32, []byte, error)
+	// Key returns the key with the ID, to decrypt records written with it.
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider with a fixed set of keys. To rotate keys, add a
+// new key and make it the current one. Pages are encrypted with the new key as
+// they're consolidated. The next checkpoint, in the background or by Close,
+// rewrites every page still encrypted with an old key, and the file is then
+// compacted to drop the records encrypted with old keys. Once the DB has been
+// closed, the old keys can be removed.
+type StaticKeys struct {
+	Current uint32
+	Keys    map[uint32][]byte
+}
+
+// CurrentKey implements KeyProvider.
+func (k StaticKeys) CurrentKey() (uint32, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+// Key implements KeyProvider.
+func (k StaticKeys) Key(id uint32) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, errors.Errorf("no key with ID %d", id)
+	}
+	return key, nil
+}
+
+// Record encryption schemes.
+const (
+	plaintext byte = iota
+	aesGCM
+)
+
+// ciphers caches the AES-GCM ciphers of keys by their IDs.
+type ciphers struct {
+	mu sync.Mutex
+	m  map[uint32]cipher.AEAD
+}
+
+func (c *ciphers) get(id uint32, key []byte) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if aead, ok := c.m[id]; ok {
+		return aead, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "key %d", id)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if c.m == nil {
+		c.m = map[uint32]cipher.AEAD{}
+	}
+	c.m[id] = aead
+	return aead, nil
+}
+
+// currentKeyID returns the ID of the key new records are encrypted with, or 0
+// if the page store isn't encrypted.
+func (s *pageStore) currentKeyID() (uint32, error) {
+	if s.keys == nil {
+		return 0, nil
+	}
+	id, _, err := s.keys.CurrentKey()
+	return id, err
+}
+
+// seal encrypts the payload of a record with the current key, if there is a
+// KeyProvider, and returns it prefixed by the scheme and the ID of the key.
+// The record type is authenticated along with the payload.
+func (s *pageStore) seal(typ byte, payload []byte) ([]byte, uint32, error) {
+	if s.keys == nil {
+		return append([]byte{plaintext}, payload...), 0, nil
+	}
+	id, key, err := s.keys.CurrentKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	if id == 0 {
+		return nil, 0, errors.New("encryption key IDs must not be zero")
+	}
+	if s.sealedKeyID != 0 && id != s.sealedKeyID {
+		s.rotated = true
+	}
+	s.sealedKeyID = id
+	aead, err := s.ciphers.get(id, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 5+aead.NonceSize(), 5+aead.NonceSize()+len(payload)+aead.Overhead())
+	buf[0] = aesGCM
+	binary.BigEndian.PutUint32(buf[1:], id)
+	nonce := buf[5:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, 0, err
+	}
+	return aead.Seal(buf, nonce, payload, []byte{typ}), id, nil
+}
+
+// open returns the payload of a record written by seal, and the ID of the key
+// it was encrypted with.
+func (s *pageStore) open(typ byte, b []byte) ([]byte, uint32, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrCorrupt
+	}
+	switch b[0] {
+	case plaintext:
+		return b[1:], 0, nil
+	case aesGCM:
+		if len(b) < 5 {
+			return nil, 0, ErrCorrupt
+		}
+		id := binary.BigEndian.Uint32(b[1:])
+		if s.keys == nil {
+			return nil, id, errors.Wrap(ErrEncryptionKey, "data is encrypted")
+		}
+		key, err := s.keys.Key(id)
+		if err != nil {
+			return nil, id, errors.Wrap(ErrEncryptionKey, err.Error())
+		}
+		aead, err := s.ciphers.get(id, key)
+		if err != nil {
+			return nil, id, err
+		}
+		if len(b) < 5+aead.NonceSize() {
+			return nil, id, ErrCorrupt
+		}
+		nonce, sealed := b[5:5+aead.NonceSize()], b[5+aead.NonceSize():]
+		payload, err := aead.Open(nil, nonce, sealed, []byte{typ})
+		if err != nil {
+			return nil, id, errors.Wrapf(ErrEncryptionKey, "key %d", id)
+		}
+		return payload, id, nil
+	}
+	return nil, 0, errors.Wrapf(ErrCorrupt, "unknown encryption scheme %d", b[0])
+}
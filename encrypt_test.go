@@ -0,0 +1,158 @@
+package skeleton
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+)
+
+func TestEncryption(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 16)
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("secret %d", i))
+	}
+	open := func(keys KeyProvider) (*DB, error) {
+		c := DefaultConfig
+		c.MaxKeysPerNode = 10
+		c.MaxDeltaCount = 1
+		c.Path = path
+		c.Encryption = keys
+		return NewDB(&c)
+	}
+	check := func(keys KeyProvider) {
+		t.Helper()
+		db, err := open(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			if v, _ := db.Get(intToKey(i)); !bytes.Equal(v, value(i)) {
+				t.Errorf("db.Get(%q) = %q; not %q", intToKey(i), v, value(i))
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := open(StaticKeys{Current: 1, Keys: map[uint32][]byte{1: key1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if err := db.Put(intToKey(i), value(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		consolidateAll(db)
+		db.split(rootPage)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("secret")) {
+		t.Errorf("the page store should be encrypted")
+	}
+
+	// Opening with no key, a wrong key or a missing key is refused.
+	for _, keys := range []KeyProvider{
+		nil,
+		StaticKeys{Current: 1, Keys: map[uint32][]byte{1: key2}},
+		StaticKeys{Current: 2, Keys: map[uint32][]byte{2: key2}},
+	} {
+		if db, err := open(keys); errors.Cause(err) != ErrEncryptionKey {
+			t.Errorf("open(%+v) = %+v; not ErrEncryptionKey", keys, err)
+			if err == nil {
+				db.Close()
+			}
+		}
+	}
+	if _, err := open(StaticKeys{Current: 1, Keys: map[uint32][]byte{1: []byte("short")}}); err == nil {
+		t.Errorf("open with an invalid key should fail")
+	}
+
+	// After rotating the key, pages are rewritten with the new key, so the old
+	// key isn't needed once the DB is closed.
+	check(StaticKeys{Current: 2, Keys: map[uint32][]byte{1: key1, 2: key2}})
+	check(StaticKeys{Current: 2, Keys: map[uint32][]byte{2: key2}})
+
+	// The file was compacted, so no record encrypted with the old key is left.
+	s, err := openPageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	s.keys = StaticKeys{Current: 2, Keys: map[uint32][]byte{2: key2}}
+	for off := int64(storeHeaderSize); off < s.size; {
+		typ, sealed, err := s.read(off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, keyID, err := s.open(typ, sealed); err != nil || keyID != 2 {
+			t.Errorf("record at %d is encrypted with key %d: %+v", off, keyID, err)
+		}
+		off += recordHeaderSize + int64(len(sealed))
+	}
+}
+
+func TestEncryptionMigrate(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("a"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unencrypted page store is encrypted once a key is provided.
+	c.Encryption = StaticKeys{Current: 1, Keys: map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)}}
+	db, err = NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get([]byte("a")); !bytes.Equal(v, []byte("secret")) {
+		t.Errorf("db.Get(a) = %q; not secret", v)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The unencrypted records are compacted away.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("secret")) {
+		t.Errorf("the page store should be encrypted")
+	}
+
+	c.Encryption = nil
+	if _, err := NewDB(&c); errors.Cause(err) != ErrEncryptionKey {
+		t.Errorf("NewDB without a key = %+v; not ErrEncryptionKey", err)
+	}
+}
@@ -0,0 +1,54 @@
+package skeleton
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// evict evicts data pages to the page store until the memory used is within
+// the memory budget, using the CLOCK algorithm: the clock hand sweeps over the
+// mapping table, and pages that have been used since it last passed get a
+// second chance. It gives up after two sweeps, since the remaining pages have
+// deltas, are index nodes or can't be written.
+func (db *DB) evict() {
+	pages := *db.pages
+	for i := 0; i < 2*len(pages) && db.memoryUsed() > db.config.MemoryBudget; i++ {
+		db.clockHand = db.clockHand%pageID(len(pages)) + 1
+		db.evictPage(db.clockHand)
+	}
+}
+
+// evictPage replaces a consolidated data page with a stub pointing at it in
+// the page store, unless the page has been used since it was last checked.
+func (db *DB) evictPage(id pageID) bool {
+	slot := db.getPage(id)
+	d := slot.next
+	if d == nil || d.page == nil || d.page.stub || d.page.key != nil {
+		return false
+	}
+	if atomic.SwapInt32(&slot.referenced, 0) != 0 {
+		return false
+	}
+	offset, keyID := d.page.offset, d.page.keyID
+	if offset == 0 {
+		var err error
+		if offset, keyID, err = db.store.writePage(d.page); err != nil {
+			log.Printf("evict %+v: %+v", id, err)
+			return false
+		}
+	}
+	stub := &delta{
+		page: &page{
+			id:     id,
+			ns:     d.page.ns,
+			offset: offset,
+			keyID:  keyID,
+			stub:   true,
+		},
+	}
+	if !db.savePageNext(id, d, stub) {
+		return false
+	}
+	db.addUsage(usage{}.minus(chainUsage(d)))
+	return true
+}
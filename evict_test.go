@@ -0,0 +1,88 @@
+package skeleton
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestEvict(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 500
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.MaxKeysPerNode = 10
+	c.MaxDeltaCount = count
+	c.Path = path
+	// Don't evict until the pages have been split.
+	c.MemoryBudget = 1 << 30
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.ns.config.MaxDeltaCount = 1
+	for i := 0; i < 6; i++ {
+		consolidateAll(db)
+		db.split(rootPage)
+	}
+	db.ns.config.MaxDeltaCount = count
+
+	before := db.memoryUsed()
+	db.config.MemoryBudget = before / 4
+	// The first sweep clears the referenced bits, the second evicts.
+	db.evict()
+	after := db.memoryUsed()
+	if after > db.config.MemoryBudget {
+		t.Fatalf("db.memoryUsed() = %d; not <= %d", after, db.config.MemoryBudget)
+	}
+	evicted := 0
+	for _, slot := range *db.pages {
+		if slot.next != nil && slot.next.page != nil && slot.next.page.stub {
+			evicted++
+		}
+	}
+	if evicted == 0 {
+		t.Fatalf("expected pages to be evicted")
+	}
+
+	// Evicted pages are read back transparently.
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if v, _ := db.Get(k); !bytes.Equal(v, k) {
+			t.Fatalf("db.Get(%q) = %q; not %q", k, v, k)
+		}
+	}
+	db.evict()
+	v := []byte("new")
+	for i := 0; i < count; i += 10 {
+		k := intToKey(i)
+		if err := db.Put(k, v); err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := db.Get(k); !bytes.Equal(out, v) {
+			t.Fatalf("db.Get(%q) = %q; not %q", k, out, v)
+		}
+	}
+	if n := db.Count(nil, nil, zeroTime); n != count {
+		t.Fatalf("db.Count(nil, nil) = %d; not %d", n, count)
+	}
+
+	// Stop the worker so it doesn't evict while the pages are measured.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.memoryUsed(), pagesSize(db); got != want {
+		t.Fatalf("db.memoryUsed() = %d; not %d", got, want)
+	}
+}
@@ -0,0 +1,312 @@
+package skeleton
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCorrupt is returned when persisted data can't be decoded.
+var ErrCorrupt = errors.New("corrupt data")
+
+// Page kinds.
+const (
+	dataPage byte = iota
+	indexPage
+)
+
+// Value flags.
+const (
+	valueTombstone byte = 1 << iota
+	valueMerge
+	valueNil
+)
+
+// encoder appends variable length integers and length prefixed byte slices to
+// a buffer.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+func (e *encoder) varint(x int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], x)]...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// decoder reads what encoder writes. After the first error, all reads return
+// zero values and err is set to ErrCorrupt.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	d.buf = nil
+	d.err = ErrCorrupt
+}
+
+func (d *decoder) byte() byte {
+	if len(d.buf) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+// bytes returns a copy of the next byte slice, so the buffer can be reused.
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if uint64(len(d.buf)) < n {
+		d.fail()
+		return nil
+	}
+	b := append([]byte{}, d.buf[:n]...)
+	d.buf = d.buf[n:]
+	return b
+}
+
+// encodePage serializes a consolidated page. Index pages are stored as their
+// separator and children, and data pages as their bounds, siblings, shared key
+// prefix and every version of their keys. Deltas aren't part of a page, so
+// they must have been consolidated first.
+func encodePage(p *page) []byte {
+	var e encoder
+	if p.key != nil {
+		e.byte(indexPage)
+		e.uvarint(uint64(p.id))
+		e.bytes(p.key)
+		e.uvarint(uint64(p.left))
+		e.uvarint(uint64(p.right))
+		return e.buf
+	}
+
+	e.byte(dataPage)
+	e.uvarint(uint64(p.id))
+	if p.high == nil {
+		e.byte(0)
+	} else {
+		e.byte(1)
+		e.bytes(p.high)
+	}
+	e.uvarint(uint64(p.prev))
+	e.uvarint(uint64(p.next))
+	e.bytes(p.prefix)
+	e.uvarint(uint64(len(p.keys)))
+	for _, k := range p.keys {
+		e.bytes(k.key)
+		e.uvarint(uint64(len(k.values)))
+		for _, v := range k.values {
+			var flags byte
+			if v.tombstone {
+				flags |= valueTombstone
+			}
+			if v.merge {
+				flags |= valueMerge
+			}
+			if v.value == nil {
+				flags |= valueNil
+			}
+			e.varint(v.time.UnixNano())
+			e.byte(flags)
+			e.bytes(v.value)
+		}
+	}
+	return e.buf
+}
+
+// decodePage deserializes a page written by encodePage into the namespace.
+func decodePage(b []byte, ns *Namespace) (*page, error) {
+	d := decoder{buf: b}
+	p := &page{ns: ns}
+	kind := d.byte()
+	p.id = pageID(d.uvarint())
+	switch kind {
+	case indexPage:
+		p.key = d.bytes()
+		p.left = pageID(d.uvarint())
+		p.right = pageID(d.uvarint())
+	case dataPage:
+		if d.byte() != 0 {
+			p.high = d.bytes()
+		}
+		p.prev = pageID(d.uvarint())
+		p.next = pageID(d.uvarint())
+		if prefix := d.bytes(); len(prefix) > 0 {
+			p.prefix = prefix
+		}
+		n := d.uvarint()
+		if n > uint64(len(d.buf)) {
+			return nil, ErrCorrupt
+		}
+		if n > 0 {
+			p.keys = make([]*key, n)
+		}
+		for i := range p.keys {
+			k := &key{key: d.bytes()}
+			n := d.uvarint()
+			if n > uint64(len(d.buf)) {
+				return nil, ErrCorrupt
+			}
+			k.values = make([]value, n)
+			for j := range k.values {
+				v := &k.values[j]
+				v.time = time.Unix(0, d.varint())
+				flags := d.byte()
+				v.value = d.bytes()
+				v.tombstone = flags&valueTombstone != 0
+				v.merge = flags&valueMerge != 0
+				if flags&valueNil != 0 {
+					v.value = nil
+				}
+			}
+			if n == 0 {
+				return nil, ErrCorrupt
+			}
+			p.keys[i] = k
+		}
+		p.updateCounts()
+	default:
+		return nil, ErrCorrupt
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.buf) > 0 {
+		return nil, ErrCorrupt
+	}
+	return p, nil
+}
+
+// checkpoint is the state needed to reopen a DB from its page store.
+type checkpoint struct {
+	largestPageID int64
+	// lastTime is the last timestamp handed out, so timestamps stay increasing
+	// across restarts.
+	lastTime   int64
+	namespaces []namespaceRecord
+	pages      []pageRecord
+}
+
+// namespaceRecord describes a namespace in a checkpoint. The default
+// namespace has the empty name.
+type namespaceRecord struct {
+	name           string
+	root           pageID
+	comparator     string
+	maxKeysPerNode int
+	maxDeltaCount  int
+	gcTime         time.Duration
+}
+
+// pageRecord is the location of a page in the page store, the index of its
+// namespace in the checkpoint and the ID of the key it's encrypted with.
+type pageRecord struct {
+	id     pageID
+	offset int64
+	ns     int
+	keyID  uint32
+}
+
+func (c *checkpoint) encode() []byte {
+	var e encoder
+	e.varint(c.largestPageID)
+	e.varint(c.lastTime)
+	e.uvarint(uint64(len(c.namespaces)))
+	for _, ns := range c.namespaces {
+		e.bytes([]byte(ns.name))
+		e.uvarint(uint64(ns.root))
+		e.bytes([]byte(ns.comparator))
+		e.varint(int64(ns.maxKeysPerNode))
+		e.varint(int64(ns.maxDeltaCount))
+		e.varint(int64(ns.gcTime))
+	}
+	e.uvarint(uint64(len(c.pages)))
+	for _, p := range c.pages {
+		e.uvarint(uint64(p.id))
+		e.uvarint(uint64(p.offset))
+		e.uvarint(uint64(p.ns))
+		e.uvarint(uint64(p.keyID))
+	}
+	return e.buf
+}
+
+// decodeCheckpoint deserializes a checkpoint.
+func decodeCheckpoint(b []byte) (*checkpoint, error) {
+	d := decoder{buf: b}
+	c := &checkpoint{
+		largestPageID: d.varint(),
+		lastTime:      d.varint(),
+	}
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		return nil, ErrCorrupt
+	}
+	c.namespaces = make([]namespaceRecord, n)
+	for i := range c.namespaces {
+		c.namespaces[i] = namespaceRecord{
+			name:           string(d.bytes()),
+			root:           pageID(d.uvarint()),
+			comparator:     string(d.bytes()),
+			maxKeysPerNode: int(d.varint()),
+			maxDeltaCount:  int(d.varint()),
+			gcTime:         time.Duration(d.varint()),
+		}
+	}
+	n = d.uvarint()
+	if n > uint64(len(d.buf)) {
+		return nil, ErrCorrupt
+	}
+	c.pages = make([]pageRecord, n)
+	for i := range c.pages {
+		c.pages[i] = pageRecord{
+			id:     pageID(d.uvarint()),
+			offset: int64(d.uvarint()),
+			ns:     int(d.uvarint()),
+			keyID:  uint32(d.uvarint()),
+		}
+		if c.pages[i].ns >= len(c.namespaces) || c.pages[i].id <= 0 || int64(c.pages[i].id) > c.largestPageID {
+			return nil, ErrCorrupt
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return c, nil
+}
@@ -0,0 +1,188 @@
+package skeleton
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// byIndexKey implements sort.Interface for indexes into keys, ordered by the
+// keys they point to.
+type byIndexKey struct {
+	order []int
+	keys  [][]byte
+	cmp   Comparator
+}
+
+func (a byIndexKey) Len() int      { return len(a.order) }
+func (a byIndexKey) Swap(i, j int) { a.order[i], a.order[j] = a.order[j], a.order[i] }
+func (a byIndexKey) Less(i, j int) bool {
+	return a.cmp.Compare(a.keys[a.order[i]], a.keys[a.order[j]]) < 0
+}
+
+// GetMany gets multiple values from the database. The values and whether they
+// were found are returned in the same order as keys.
+func (db *DB) GetMany(keys [][]byte) ([][]byte, []bool) {
+	return db.ns.GetMany(keys)
+}
+
+// GetMany gets multiple values from the namespace. The values and whether they
+// were found are returned in the same order as keys.
+func (ns *Namespace) GetMany(keys [][]byte) ([][]byte, []bool) {
+	return ns.db.getManyAt(ns, nil, keys, zeroTime)
+}
+
+// GetMany gets multiple values from the database. The values and whether they
+// were found are returned in the same order as keys.
+func (t *Txn) GetMany(keys [][]byte) ([][]byte, []bool) {
+	return t.In(t.db.ns).GetMany(keys)
+}
+
+// GetMany gets multiple values from the namespace. The values and whether they
+// were found are returned in the same order as keys.
+func (t NamespaceTxn) GetMany(keys [][]byte) ([][]byte, []bool) {
+	if t.readOnly {
+		return t.db.getManyAt(t.ns, nil, keys, t.time)
+	}
+	return t.db.getManyAt(t.ns, t.Txn, keys, zeroTime)
+}
+
+// getManyAt sorts the keys so that all keys on the same data page can be
+// resolved with a single descent of the tree and a single walk of the delta
+// chain.
+func (db *DB) getManyAt(ns *Namespace, txn *Txn, keys [][]byte, at time.Time) ([][]byte, []bool) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Sort(byIndexKey{order, keys, ns.comparator()})
+
+	values := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
+	for i := 0; i < len(order); {
+		id, d, high, err := db.findLeaf(ns, keys[order[i]])
+		if err != nil {
+			// Like getAt, the key isn't found if its page can't be read.
+			log.Printf("get %q: %+v", keys[order[i]], err)
+			i++
+			continue
+		}
+		j := i + 1
+		for high != nil && j < len(order) && ns.comparator().Compare(keys[order[j]], high) < 0 {
+			j++
+		}
+		if high == nil {
+			j = len(order)
+		}
+
+		batch := make([][]byte, j-i)
+		for n, o := range order[i:j] {
+			batch[n] = keys[o]
+		}
+		lookups, deltaCount := db.lookupChainMany(ns, d, txn, batch, at)
+		for n, o := range order[i:j] {
+			values[o], found[o] = db.resolve(keys[o], &lookups[n])
+		}
+		if txn != nil {
+			if err := db.addReadIntents(ns, txn, id, d, batch); err != nil {
+				log.Printf("get %q: %+v", keys[order[i]], err)
+				for _, o := range order[i:j] {
+					values[o], found[o] = nil, false
+				}
+			}
+		}
+
+		// Check if the depth is too high, and if so, queue for consolidation.
+		if deltaCount > ns.config.MaxDeltaCount {
+			db.consolidateQueue <- id
+		}
+		i = j
+	}
+	return values, found
+}
+
+// addReadIntents is like addReadIntent, but adds the intents on all of the
+// keys, which were read from the delta chain d of the data page, with a single
+// update of the chain. If the chain has changed since, they're added one at a
+// time instead, which fails if a page can't be read from the page store.
+func (db *DB) addReadIntents(ns *Namespace, txn *Txn, id pageID, d *delta, keys [][]byte) error {
+	if txn.Status() != StatusPending {
+		return nil
+	}
+	head := d
+	var u usage
+	for _, k := range keys {
+		key := &key{
+			key:  k,
+			txn:  txn,
+			read: true,
+			seq:  txn.nextSeq(),
+		}
+		if err := db.checkConflict(ns, d, key); err != nil {
+			return nil
+		}
+		head = &delta{key: key, next: head}
+		u = u.plus(head.usage())
+	}
+	if db.savePageNext(id, d, head) {
+		db.addUsage(u)
+		return nil
+	}
+	for _, k := range keys {
+		if err := db.addReadIntent(ns, txn, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupChainMany is like lookupChain, but resolves all of the keys, which
+// must be sorted, in one walk of the delta chain.
+func (db *DB) lookupChainMany(ns *Namespace, d *delta, txn *Txn, keys [][]byte, at time.Time) ([]lookup, int) {
+	lookups := make([]lookup, len(keys))
+	remaining := len(keys)
+	add := func(i int, k *key) {
+		if lookups[i].done {
+			return
+		}
+		lookups[i].add(k, at)
+		if lookups[i].done {
+			remaining--
+		}
+	}
+
+	deltaCount := 0
+	for ; d != nil && remaining > 0; d = d.next {
+		if (d.key == nil) == (d.page == nil) {
+			panic("invariant: exactly one of delta.key, delta.page must be set")
+		}
+
+		if p := d.page; p != nil {
+			// Both the page keys and keys are sorted, so each key is searched for
+			// after the previous one.
+			i := 0
+			for j, k := range keys {
+				i += p.searchFrom(ns.comparator(), i, k)
+				if i == len(p.keys) {
+					break
+				}
+				if p.compareKey(ns.comparator(), i, k) == 0 {
+					add(j, p.keys[i])
+				}
+			}
+			break
+		}
+
+		deltaCount++
+		if !d.key.visibleTo(txn) {
+			continue
+		}
+		start := sort.Search(len(keys), func(i int) bool {
+			return ns.comparator().Compare(keys[i], d.key.key) >= 0
+		})
+		for i := start; i < len(keys) && d.key.covers(ns.comparator(), keys[i]); i++ {
+			add(i, d.key)
+		}
+	}
+	return lookups, deltaCount
+}
@@ -0,0 +1,118 @@
+package skeleton
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestGetMany(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 200
+
+	c := DefaultConfig
+	c.MaxKeysPerNode = 10
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	splitAll(db)
+	// Leave some writes as deltas.
+	for i := 0; i < count; i += 3 {
+		k := intToKey(i)
+		if err := db.Put(k, intPrefix("new", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteRange([]byte("key5"), []byte("key6")); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys [][]byte
+	for i := count + 10; i >= 0; i -= 2 {
+		keys = append(keys, intToKey(i))
+	}
+	keys = append(keys, intToKey(4), intToKey(4))
+
+	values, found := db.GetMany(keys)
+	for i, k := range keys {
+		v, ok := db.Get(k)
+		if !bytes.Equal(values[i], v) || found[i] != ok {
+			t.Errorf("db.GetMany()[%d] = %q, %v; db.Get(%q) = %q, %v", i, values[i], found[i], k, v, ok)
+		}
+	}
+}
+
+func TestGetManyTxn(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	if err := db.Put(a, a); err != nil {
+		t.Fatal(err)
+	}
+	txn := db.NewTxn()
+	if err := txn.Put(b, b); err != nil {
+		t.Fatal(err)
+	}
+
+	values, found := txn.GetMany([][]byte{c, b, a})
+	want := [][]byte{nil, b, a}
+	for i := range want {
+		if !bytes.Equal(values[i], want[i]) || found[i] != (want[i] != nil) {
+			t.Errorf("txn.GetMany()[%d] = %q, %v; not %q", i, values[i], found[i], want[i])
+		}
+	}
+
+	// The reads were registered as intents.
+	txn2 := db.NewTxn()
+	if err := txn2.Put(c, c); err != ErrTxnConflict {
+		t.Fatalf("txn2.Put(%q) = %v; not %v", c, err, ErrTxnConflict)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetManyConflict(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, b := []byte("a"), []byte("b")
+	txn := db.NewTxn()
+	if err := txn.Put(a, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put(b, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both reads conflict with txn, but only the first one aborts txn2.
+	txn2 := db.NewTxn()
+	txn2.GetMany([][]byte{a, b})
+	if status := txn2.Status(); status != StatusAborted {
+		t.Errorf("txn2.Status() = %v; not %v", status, StatusAborted)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/d4l3k/skeletondb
+
+go 1.22
+
+require (
+	github.com/fortytw2/leaktest v1.3.0
+	github.com/golang/snappy v1.0.0
+	github.com/jpillora/backoff v1.0.0
+	github.com/klauspost/compress v1.18.0
+	github.com/pkg/errors v0.9.1
+)
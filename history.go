@@ -0,0 +1,83 @@
+package skeleton
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Version is a single version of a key.
+type Version struct {
+	Value     []byte
+	Time      time.Time
+	Tombstone bool
+}
+
+// byTime implements sort.Interface for []value, newest first.
+type byTime []value
+
+func (a byTime) Len() int           { return len(a) }
+func (a byTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTime) Less(i, j int) bool { return a[i].time.After(a[j].time) }
+
+// History returns every committed version of a key between from and to
+// inclusive, newest first. Deletes are returned as tombstones and merge
+// operands are returned as the full values they produce. A zero from or to
+// leaves that side of the range unbounded.
+func (db *DB) History(k []byte, from, to time.Time) []Version {
+	return db.ns.History(k, from, to)
+}
+
+// History returns every committed version of a key in the namespace. See
+// DB.History.
+func (ns *Namespace) History(k []byte, from, to time.Time) []Version {
+	return ns.db.history(ns, k, from, to)
+}
+
+// history returns no versions if the key's page can't be read from the page
+// store, and logs the error.
+func (db *DB) history(ns *Namespace, k []byte, from, to time.Time) []Version {
+	_, d, _, err := db.findLeaf(ns, k)
+	if err != nil {
+		log.Printf("history %q: %+v", k, err)
+		return nil
+	}
+	var versions []Version
+	for _, v := range db.chainHistory(ns, d, k) {
+		if !isZeroTime(from) && v.time.Before(from) {
+			continue
+		}
+		if !isZeroTime(to) && v.time.After(to) {
+			continue
+		}
+		versions = append(versions, Version{
+			Value:     v.value,
+			Time:      v.time,
+			Tombstone: v.tombstone,
+		})
+	}
+	return versions
+}
+
+// chainHistory returns every committed version of a key in the delta chain,
+// newest first, with merge operands collapsed into full values.
+func (db *DB) chainHistory(ns *Namespace, d *delta, k []byte) []value {
+	var values []value
+	for ; d != nil; d = d.next {
+		if d.page != nil {
+			if entry := d.page.find(ns.comparator(), k); entry != nil {
+				values = append(values, entry.values...)
+			}
+			continue
+		}
+		if d.key.read || !d.key.committed() || !d.key.covers(ns.comparator(), k) {
+			continue
+		}
+		values = append(values, d.key.stamp().values...)
+	}
+	sort.Stable(byTime(values))
+	if hasMerge(values) {
+		db.collapseMerges(k, values)
+	}
+	return values
+}
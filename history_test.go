@@ -0,0 +1,71 @@
+package skeleton
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestHistory(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("key")
+	for i := 0; i < 20; i++ {
+		if err := db.Put(k, intToKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete(k); err != nil {
+		t.Fatal(err)
+	}
+
+	// Half of the versions are consolidated into the page, the rest are deltas.
+	db.consolidate(rootPage)
+	for i := 20; i < 25; i++ {
+		if err := db.Put(k, intToKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Uncommitted versions aren't part of the history.
+	txn := db.NewTxn()
+	if err := txn.Put(k, k); err != nil {
+		t.Fatal(err)
+	}
+
+	versions := db.History(k, zeroTime, zeroTime)
+	if len(versions) != 26 {
+		t.Fatalf("len(db.History(%q)) = %d; not 26", k, len(versions))
+	}
+	for i := 1; i < len(versions); i++ {
+		if !versions[i-1].Time.After(versions[i].Time) {
+			t.Fatalf("versions[%d].Time = %s; not after %s", i-1, versions[i-1].Time, versions[i].Time)
+		}
+	}
+	if want := intToKey(24); !bytes.Equal(versions[0].Value, want) {
+		t.Fatalf("versions[0].Value = %q; not %q", versions[0].Value, want)
+	}
+	if !versions[5].Tombstone {
+		t.Fatalf("versions[5] = %+v; should be a tombstone", versions[5])
+	}
+
+	from, to := versions[10].Time, versions[3].Time
+	ranged := db.History(k, from, to)
+	if len(ranged) != 8 {
+		t.Fatalf("len(db.History(%q, %s, %s)) = %d; not 8", k, from, to, len(ranged))
+	}
+	if !ranged[0].Time.Equal(to) || !ranged[7].Time.Equal(from) {
+		t.Fatalf("db.History(%q, %s, %s) = %+v; wrong bounds", k, from, to, ranged)
+	}
+
+	if versions := db.History([]byte("missing"), zeroTime, zeroTime); len(versions) != 0 {
+		t.Fatalf("db.History(missing) = %+v; not empty", versions)
+	}
+}
@@ -0,0 +1,124 @@
+package skeleton
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidInt64 is returned when incrementing a value that isn't an 8 byte
+// integer.
+var ErrInvalidInt64 = errors.New("the value is not an 8 byte integer")
+
+// EncodeInt64 encodes n as 8 big endian bytes, as used by Increment.
+func EncodeInt64(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+// DecodeInt64 decodes an integer written by Increment or EncodeInt64.
+func DecodeInt64(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, ErrInvalidInt64
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// Increment atomically adds delta to the integer stored at the key and returns
+// the new value. A missing or deleted key is treated as 0. Values are encoded
+// with EncodeInt64.
+func (db *DB) Increment(k []byte, delta int64) (int64, error) {
+	return db.ns.Increment(k, delta)
+}
+
+// Increment atomically adds delta to the integer stored at the key in the
+// namespace. See DB.Increment.
+func (ns *Namespace) Increment(k []byte, delta int64) (int64, error) {
+	if ns.indexed() {
+		var n int64
+		err := ns.Txn(func(txn NamespaceTxn) error {
+			var err error
+			n, err = txn.Increment(k, delta)
+			return err
+		})
+		return n, err
+	}
+	return ns.db.increment(ns, nil, k, delta)
+}
+
+// Increment atomically adds delta to the integer stored at the key and returns
+// the new value. A missing or deleted key is treated as 0. Values are encoded
+// with EncodeInt64.
+func (t *Txn) Increment(k []byte, delta int64) (int64, error) {
+	return t.In(t.db.ns).Increment(k, delta)
+}
+
+// Increment atomically adds delta to the integer stored at the key in the
+// namespace. See Txn.Increment.
+func (t NamespaceTxn) Increment(k []byte, delta int64) (int64, error) {
+	if err := t.writable(); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := t.updateIndexes(k, func() error {
+		var err error
+		n, err = t.db.increment(t.ns, t.Txn, k, delta)
+		return err
+	})
+	return n, err
+}
+
+// increment reads the current value from the data page and writes the new one
+// with a single compare and swap of that page, so no other write can land in
+// between.
+func (db *DB) increment(ns *Namespace, txn *Txn, k []byte, n int64) (int64, error) {
+	if err := db.checkMemoryLimit(); err != nil {
+		return 0, err
+	}
+	key := &key{
+		key: k,
+		txn: txn,
+	}
+	if txn != nil {
+		key.seq = txn.nextSeq()
+	}
+	for {
+		id, d, _, err := db.findLeaf(ns, k)
+		if err != nil {
+			return 0, err
+		}
+		if err := db.checkConflict(ns, d, key); err != nil {
+			return 0, err
+		}
+
+		l, _ := db.lookupChain(ns, d, txn, k, zeroTime)
+		current, _ := db.resolve(k, &l)
+		var sum int64
+		if current != nil {
+			var err error
+			if sum, err = DecodeInt64(current); err != nil {
+				return 0, err
+			}
+		}
+		sum += n
+
+		gen := db.startStamp()
+		key.values = []value{
+			{
+				value: EncodeInt64(sum),
+				time:  db.now(),
+			},
+		}
+		insert := delta{
+			key:  key,
+			next: d,
+		}
+		saved := db.savePageNext(id, d, &insert)
+		db.endStamp(gen)
+		if saved {
+			db.addUsage(insert.usage())
+			return sum, nil
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package skeleton
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestIncrement(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+	const workers = 10
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("seq")
+	var done sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			for j := 0; j < count; j++ {
+				if _, err := db.Increment(k, 1); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	done.Wait()
+
+	v, _ := db.Get(k)
+	n, err := DecodeInt64(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != count*workers {
+		t.Fatalf("counter = %d; not %d", n, count*workers)
+	}
+
+	if n, err := db.Increment(k, -count*workers); err != nil || n != 0 {
+		t.Fatalf("db.Increment() = %d, %v; not 0, nil", n, err)
+	}
+}
+
+func TestIncrementTxn(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("seq")
+	txn := db.NewTxn()
+	if n, err := txn.Increment(k, 5); err != nil || n != 5 {
+		t.Fatalf("txn.Increment() = %d, %v; not 5, nil", n, err)
+	}
+	if n, err := txn.Increment(k, 5); err != nil || n != 10 {
+		t.Fatalf("txn.Increment() = %d, %v; not 10, nil", n, err)
+	}
+	if _, err := db.Increment(k, 1); err != ErrTxnConflict {
+		t.Fatalf("db.Increment() = %v; not %v", err, ErrTxnConflict)
+	}
+	if err := txn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.Increment(k, 1); err != nil || n != 1 {
+		t.Fatalf("db.Increment() = %d, %v; not 1, nil", n, err)
+	}
+}
+
+func TestIncrementInvalid(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("key")
+	if err := db.Put(k, k); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Increment(k, 1); err != ErrInvalidInt64 {
+		t.Fatalf("db.Increment() = %v; not %v", err, ErrInvalidInt64)
+	}
+}
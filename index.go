@@ -0,0 +1,339 @@
+package skeleton
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrIndexExists is returned when creating an index that already exists.
+	ErrIndexExists = errors.New("the index already exists")
+	// ErrNoIndex is returned when scanning an index that doesn't exist.
+	ErrNoIndex = errors.New("the index doesn't exist")
+)
+
+// IndexExtractor returns the index keys of a key and its value.
+type IndexExtractor func(key, value []byte) [][]byte
+
+// index is a secondary index. Entries are stored in their own namespace as
+// the length prefixed index key followed by the primary key.
+type index struct {
+	name    string
+	extract IndexExtractor
+	entries *Namespace
+}
+
+// CreateIndex creates a secondary index on the database. See
+// Namespace.CreateIndex.
+func (db *DB) CreateIndex(name string, extract IndexExtractor) error {
+	return db.ns.CreateIndex(name, extract)
+}
+
+// indexNamespace returns the name of the namespace holding the entries of an
+// index. The namespace name is length prefixed so names containing NUL bytes
+// can't collide.
+func indexNamespace(ns, name string) string {
+	return reservedPrefix + "index\x00" + strconv.Itoa(len(ns)) + "\x00" + ns + name
+}
+
+// CreateIndex creates a secondary index on the namespace. extract is called
+// with every key and value written to the namespace, and the index entries
+// are updated in the same transaction as the write. Existing keys are indexed
+// when the index is created. Index definitions aren't persisted, so indexes
+// have to be created again after the database is reopened, which replaces the
+// entries left from before.
+func (ns *Namespace) CreateIndex(name string, extract IndexExtractor) error {
+	c := ns.config
+	c.Comparator = BytewiseComparator
+	entries, err := ns.db.namespace(indexNamespace(ns.name, name), &c)
+	if err != nil {
+		return err
+	}
+	idx := &index{
+		name:    name,
+		extract: extract,
+		entries: entries,
+	}
+	for {
+		old := atomic.LoadPointer(&ns.indexes)
+		var indexes []*index
+		if old != nil {
+			indexes = *(*[]*index)(old)
+		}
+		for _, i := range indexes {
+			if i.name == name {
+				return ErrIndexExists
+			}
+		}
+		indexes = append(append([]*index{}, indexes...), idx)
+		if atomic.CompareAndSwapPointer(&ns.indexes, old, unsafe.Pointer(&indexes)) {
+			break
+		}
+	}
+
+	// Index the existing keys. The index is already published so that
+	// concurrent writes update it, and is removed again if this fails so that
+	// creating it can be retried.
+	if err := ns.Txn(func(txn NamespaceTxn) error {
+		err := ns.backfillIndex(txn, idx)
+		if err != nil {
+			txn.Close()
+		}
+		return err
+	}); err != nil {
+		ns.removeIndex(idx)
+		return err
+	}
+	return nil
+}
+
+// backfillIndex adds the entries of the existing keys to the index, and
+// deletes the entries persisted by an earlier instance of it, which weren't
+// updated by the writes since.
+func (ns *Namespace) backfillIndex(txn NamespaceTxn, idx *index) error {
+	stale := map[string]bool{}
+	if err := ns.db.scan(idx.entries, txn.Txn, nil, nil, zeroTime, func(k, _ []byte) bool {
+		stale[string(k)] = true
+		return true
+	}); err != nil {
+		return err
+	}
+	var err error
+	if scanErr := ns.db.scan(ns, txn.Txn, nil, nil, zeroTime, func(k, v []byte) bool {
+		for _, ik := range uniqueIndexKeys(idx.extract(k, v)) {
+			entry := indexEntry(ik, k)
+			delete(stale, string(entry))
+			if err = txn.db.put(idx.entries, txn.Txn, entry, []byte{}); err != nil {
+				return false
+			}
+		}
+		return true
+	}); scanErr != nil {
+		return scanErr
+	}
+	if err != nil {
+		return err
+	}
+	for k := range stale {
+		if err := txn.db.delete(idx.entries, txn.Txn, []byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIndex unpublishes the index and deletes the entries that concurrent
+// writes added to it.
+func (ns *Namespace) removeIndex(idx *index) {
+	for {
+		old := atomic.LoadPointer(&ns.indexes)
+		var indexes []*index
+		for _, i := range *(*[]*index)(old) {
+			if i != idx {
+				indexes = append(indexes, i)
+			}
+		}
+		if atomic.CompareAndSwapPointer(&ns.indexes, old, unsafe.Pointer(&indexes)) {
+			break
+		}
+	}
+
+	var keys [][]byte
+	if err := ns.db.scan(idx.entries, nil, nil, nil, zeroTime, func(k, _ []byte) bool {
+		keys = append(keys, k)
+		return true
+	}); err != nil {
+		log.Printf("removing index %q: %+v", idx.name, err)
+	}
+	for _, k := range keys {
+		idx.entries.Delete(k)
+	}
+}
+
+// getIndexes returns the indexes on the namespace.
+func (ns *Namespace) getIndexes() []*index {
+	indexes := (*[]*index)(atomic.LoadPointer(&ns.indexes))
+	if indexes == nil {
+		return nil
+	}
+	return *indexes
+}
+
+// indexed returns whether the namespace has any indexes.
+func (ns *Namespace) indexed() bool {
+	return len(ns.getIndexes()) > 0
+}
+
+// getIndex returns the named index or nil if it doesn't exist.
+func (ns *Namespace) getIndex(name string) *index {
+	for _, idx := range ns.getIndexes() {
+		if idx.name == name {
+			return idx
+		}
+	}
+	return nil
+}
+
+// indexEntry returns the key of the index entry for the primary key.
+func indexEntry(indexKey, primaryKey []byte) []byte {
+	prefix := indexPrefix(indexKey)
+	return append(prefix[:len(prefix):len(prefix)], primaryKey...)
+}
+
+// indexPrefix returns the prefix of all index entries with the index key.
+func indexPrefix(indexKey []byte) []byte {
+	prefix := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(indexKey))
+	n := binary.PutUvarint(prefix, uint64(len(indexKey)))
+	return append(prefix[:n], indexKey...)
+}
+
+// uniqueIndexKeys removes duplicates from the index keys.
+func uniqueIndexKeys(keys [][]byte) [][]byte {
+	var unique [][]byte
+	for _, k := range keys {
+		if !containsKey(unique, k) {
+			unique = append(unique, k)
+		}
+	}
+	return unique
+}
+
+func containsKey(keys [][]byte, k []byte) bool {
+	for _, k2 := range keys {
+		if bytes.Equal(k, k2) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateIndexes runs write, which writes to k, and updates the index entries
+// of k to match in the same transaction.
+func (t NamespaceTxn) updateIndexes(k []byte, write func() error) error {
+	indexes := t.ns.getIndexes()
+	if len(indexes) == 0 {
+		return write()
+	}
+	oldLookup, err := t.db.get(t.ns, t.Txn, k, zeroTime)
+	if err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	newLookup, err := t.db.get(t.ns, t.Txn, k, zeroTime)
+	if err != nil {
+		return err
+	}
+	old, _ := t.db.resolve(k, &oldLookup)
+	new, _ := t.db.resolve(k, &newLookup)
+	return t.db.updateIndexEntries(t.ns, t.Txn, k, old, new, oldLookup.exists(), newLookup.exists())
+}
+
+// updateIndexEntries updates the index entries of k, whose value changed from
+// old to new, as part of txn if it's set.
+func (db *DB) updateIndexEntries(ns *Namespace, txn *Txn, k, old, new []byte, oldOK, newOK bool) error {
+	for _, idx := range ns.getIndexes() {
+		var oldKeys, newKeys [][]byte
+		if oldOK {
+			oldKeys = uniqueIndexKeys(idx.extract(k, old))
+		}
+		if newOK {
+			newKeys = uniqueIndexKeys(idx.extract(k, new))
+		}
+		for _, ik := range oldKeys {
+			if containsKey(newKeys, ik) {
+				continue
+			}
+			if err := db.delete(idx.entries, txn, indexEntry(ik, k)); err != nil {
+				return err
+			}
+		}
+		for _, ik := range newKeys {
+			if containsKey(oldKeys, ik) {
+				continue
+			}
+			if err := db.put(idx.entries, txn, indexEntry(ik, k), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeIndexedRange removes the index entries of all keys in [start, end).
+func (t NamespaceTxn) removeIndexedRange(start, end []byte) error {
+	indexes := t.ns.getIndexes()
+	if len(indexes) == 0 {
+		return nil
+	}
+	var err error
+	if scanErr := t.db.scan(t.ns, t.Txn, start, end, zeroTime, func(k, v []byte) bool {
+		for _, idx := range indexes {
+			for _, ik := range uniqueIndexKeys(idx.extract(k, v)) {
+				if err = t.db.delete(idx.entries, t.Txn, indexEntry(ik, k)); err != nil {
+					return false
+				}
+			}
+		}
+		return true
+	}); scanErr != nil {
+		return scanErr
+	}
+	return err
+}
+
+// IndexScan returns the keys that have the index key in the named index, in
+// order.
+func (db *DB) IndexScan(name string, indexKey []byte) ([][]byte, error) {
+	return db.ns.IndexScan(name, indexKey)
+}
+
+// IndexScan returns the keys of the namespace that have the index key in the
+// named index, in order.
+func (ns *Namespace) IndexScan(name string, indexKey []byte) ([][]byte, error) {
+	return ns.db.indexScan(ns, nil, name, indexKey)
+}
+
+// IndexScan returns the keys that have the index key in the named index, in
+// order.
+func (t *Txn) IndexScan(name string, indexKey []byte) ([][]byte, error) {
+	return t.In(t.db.ns).IndexScan(name, indexKey)
+}
+
+// IndexScan returns the keys of the namespace that have the index key in the
+// named index, in order.
+func (t NamespaceTxn) IndexScan(name string, indexKey []byte) ([][]byte, error) {
+	if t.readOnly {
+		return t.db.indexScanAt(t.ns, nil, name, indexKey, t.time)
+	}
+	return t.db.indexScan(t.ns, t.Txn, name, indexKey)
+}
+
+func (db *DB) indexScan(ns *Namespace, txn *Txn, name string, indexKey []byte) ([][]byte, error) {
+	return db.indexScanAt(ns, txn, name, indexKey, zeroTime)
+}
+
+func (db *DB) indexScanAt(ns *Namespace, txn *Txn, name string, indexKey []byte, at time.Time) ([][]byte, error) {
+	idx := ns.getIndex(name)
+	if idx == nil {
+		return nil, ErrNoIndex
+	}
+	prefix := indexPrefix(indexKey)
+	var keys [][]byte
+	err := db.scan(idx.entries, txn, prefix, nil, at, func(k, _ []byte) bool {
+		if !bytes.HasPrefix(k, prefix) {
+			return false
+		}
+		keys = append(keys, k[len(prefix):])
+		return true
+	})
+	return keys, err
+}
@@ -0,0 +1,325 @@
+package skeleton
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+// byColor indexes values of the form "color:name" by their color.
+func byColor(k, v []byte) [][]byte {
+	i := bytes.IndexByte(v, ':')
+	if i < 0 {
+		return nil
+	}
+	return [][]byte{v[:i]}
+}
+
+func indexScanEquals(t *testing.T, db *DB, color string, want ...string) {
+	t.Helper()
+
+	keys, err := db.IndexScan("color", []byte(color))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, k := range keys {
+		got = append(got, string(k))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("db.IndexScan(%q) = %q; not %q", color, got, want)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("color", byColor); err != ErrIndexExists {
+		t.Fatalf("db.CreateIndex = %+v; not ErrIndexExists", err)
+	}
+	if _, err := db.IndexScan("size", []byte("a")); err != ErrNoIndex {
+		t.Fatalf("db.IndexScan = %+v; not ErrNoIndex", err)
+	}
+
+	db.Put([]byte("1"), []byte("red:apple"))
+	db.Put([]byte("2"), []byte("green:pear"))
+	db.Put([]byte("3"), []byte("red:cherry"))
+	indexScanEquals(t, db, "red", "1", "3")
+	indexScanEquals(t, db, "green", "2")
+	// The index key is length prefixed so it doesn't match longer keys.
+	indexScanEquals(t, db, "re")
+
+	db.Put([]byte("1"), []byte("green:apple"))
+	indexScanEquals(t, db, "red", "3")
+	indexScanEquals(t, db, "green", "1", "2")
+
+	db.Delete([]byte("2"))
+	indexScanEquals(t, db, "green", "1")
+
+	db.DeleteRange([]byte("1"), []byte("3"))
+	indexScanEquals(t, db, "green")
+	indexScanEquals(t, db, "red", "3")
+}
+
+func TestIndexRollback(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("1"), []byte("red:apple"))
+
+	txn := db.NewTxn()
+	if err := txn.Put([]byte("1"), []byte("green:apple")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put([]byte("2"), []byte("green:pear")); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := txn.IndexScan("color", []byte("green"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("1"), []byte("2")}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("txn.IndexScan(green) = %q; not %q", keys, want)
+	}
+	if err := txn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexScanEquals(t, db, "red", "1")
+	indexScanEquals(t, db, "green")
+}
+
+func TestIndexBackfill(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ns, err := db.Namespace("fruit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Put([]byte("1"), []byte("red:apple"))
+	ns.Put([]byte("2"), []byte("green:pear"))
+	ns.Put([]byte("3"), []byte("red:cherry"))
+	ns.Delete([]byte("3"))
+
+	if err := ns.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ns.IndexScan("color", []byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("1")}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("ns.IndexScan(red) = %q; not %q", keys, want)
+	}
+	if _, err := db.IndexScan("color", []byte("red")); err != ErrNoIndex {
+		t.Fatalf("db.IndexScan = %+v; not ErrNoIndex", err)
+	}
+	if _, err := db.Namespace(reservedPrefix+"index", nil); err != ErrReservedNamespace {
+		t.Fatalf("db.Namespace = %+v; not ErrReservedNamespace", err)
+	}
+}
+
+// TestIndexReopen tests that recreating an index after reopening the database
+// drops the entries of keys that were changed while it didn't exist.
+func TestIndexReopen(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("a"), []byte("red:apple"))
+	db.Put([]byte("b"), []byte("red:cherry"))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Delete([]byte("a"))
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	indexScanEquals(t, db, "red", "b")
+}
+
+// TestIndexBackfillError tests that an index whose backfill fails isn't left
+// behind, so creating it can be retried.
+func TestIndexBackfillError(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.MemoryLimit = 4096
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; ; i++ {
+		if err := db.Put(intToKey(i), []byte("red:apple")); err == ErrMemoryLimit {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateIndex("color", byColor); err != ErrMemoryLimit {
+		t.Fatalf("db.CreateIndex = %+v; not ErrMemoryLimit", err)
+	}
+	if _, err := db.IndexScan("color", []byte("red")); err != ErrNoIndex {
+		t.Fatalf("db.IndexScan = %+v; not ErrNoIndex", err)
+	}
+
+	db.config.MemoryLimit = 0
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := db.IndexScan("color", []byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) == 0 {
+		t.Fatalf("db.IndexScan(red) = %q; should have keys", keys)
+	}
+}
+
+// TestIndexNilValue tests that keys with nil values are indexed like any
+// other key.
+func TestIndexNilValue(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	all := func(k, v []byte) [][]byte {
+		return [][]byte{[]byte("all")}
+	}
+	db.Put([]byte("1"), nil)
+	if err := db.CreateIndex("all", all); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("2"), nil)
+	db.Put([]byte("3"), nil)
+	db.Delete([]byte("3"))
+
+	keys, err := db.IndexScan("all", []byte("all"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("1"), []byte("2")}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("db.IndexScan(all) = %q; not %q", keys, want)
+	}
+}
+
+// TestIndexNameCollision tests that indexes whose namespace and index names
+// join to the same string keep separate entries.
+func TestIndexNameCollision(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	all := func(k, v []byte) [][]byte {
+		return [][]byte{[]byte("all")}
+	}
+	a, err := db.Namespace("a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ab, err := db.Namespace("a\x00b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CreateIndex("b\x00c", all); err != nil {
+		t.Fatal(err)
+	}
+	if err := ab.CreateIndex("c", all); err != nil {
+		t.Fatal(err)
+	}
+	a.Put([]byte("1"), []byte("1"))
+	ab.Put([]byte("2"), []byte("2"))
+
+	keys, err := a.IndexScan("b\x00c", []byte("all"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("1")}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("a.IndexScan(all) = %q; not %q", keys, want)
+	}
+	keys, err = ab.IndexScan("c", []byte("all"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("2")}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("ab.IndexScan(all) = %q; not %q", keys, want)
+	}
+}
+
+// TestIndexWriteError tests that a write to an indexed namespace that fails
+// doesn't leave intents behind that block later writes of the key.
+func TestIndexWriteError(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatal(err)
+	}
+	k := []byte("x")
+	if err := db.Put(k, []byte("red:apple")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Increment(k, 1); err != ErrInvalidInt64 {
+		t.Fatalf("db.Increment = %+v; not ErrInvalidInt64", err)
+	}
+	if err := db.Put(k, []byte("green:pear")); err != nil {
+		t.Fatalf("db.Put after a failed write = %+v", err)
+	}
+	indexScanEquals(t, db, "green", "x")
+}
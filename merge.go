@@ -0,0 +1,97 @@
+package skeleton
+
+import "github.com/pkg/errors"
+
+// ErrNoMergeOperator is returned when merging without a configured
+// MergeOperator.
+var ErrNoMergeOperator = errors.New("no MergeOperator is configured")
+
+// MergeOperator combines merge operands with the existing value of a key. It
+// allows associative updates, such as counters and append only lists, to be
+// written without reading the existing value.
+type MergeOperator interface {
+	// Merge applies the operand to the existing value of the key and returns the
+	// new value. existing is nil if the key has no value.
+	Merge(key, existing, operand []byte) []byte
+	// Name identifies the operator.
+	Name() string
+}
+
+// Merge writes a merge operand for the key into the database. Operands are
+// folded into the existing value when the key is read or consolidated.
+func (db *DB) Merge(k, operand []byte) error {
+	return db.ns.Merge(k, operand)
+}
+
+// Merge writes a merge operand for the key into the namespace. See DB.Merge.
+func (ns *Namespace) Merge(k, operand []byte) error {
+	if ns.indexed() {
+		return ns.Txn(func(txn NamespaceTxn) error {
+			return txn.Merge(k, operand)
+		})
+	}
+	return ns.db.merge(ns, nil, k, operand)
+}
+
+// Merge writes a merge operand for the key into the database. Operands are
+// folded into the existing value when the key is read or consolidated.
+func (t *Txn) Merge(k, operand []byte) error {
+	return t.In(t.db.ns).Merge(k, operand)
+}
+
+// Merge writes a merge operand for the key into the namespace. See Txn.Merge.
+func (t NamespaceTxn) Merge(k, operand []byte) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	return t.updateIndexes(k, func() error {
+		return t.db.merge(t.ns, t.Txn, k, operand)
+	})
+}
+
+func (db *DB) merge(ns *Namespace, txn *Txn, k, operand []byte) error {
+	if db.config.MergeOperator == nil {
+		return ErrNoMergeOperator
+	}
+	if err := db.checkMemoryLimit(); err != nil {
+		return err
+	}
+	return db.putKey(ns, &key{
+		key: k,
+		txn: txn,
+		values: []value{
+			{
+				value: operand,
+				merge: true,
+			},
+		},
+	})
+}
+
+// hasMerge returns whether any of the values are merge operands.
+func hasMerge(values []value) bool {
+	for _, v := range values {
+		if v.merge {
+			return true
+		}
+	}
+	return false
+}
+
+// collapseMerges replaces the merge operands in values, which are ordered
+// newest first, with the full values they produce.
+func (db *DB) collapseMerges(k []byte, values []value) {
+	var v []byte
+	for i := len(values) - 1; i >= 0; i-- {
+		switch {
+		case values[i].merge:
+			v = db.config.MergeOperator.Merge(k, v, values[i].value)
+			values[i].value = v
+			values[i].merge = false
+		case values[i].tombstone:
+			v = nil
+		default:
+			v = values[i].value
+		}
+	}
+}
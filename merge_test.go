@@ -0,0 +1,182 @@
+package skeleton
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+// addOperator treats values as big endian uint64 counters.
+type addOperator struct{}
+
+func (addOperator) Merge(key, existing, operand []byte) []byte {
+	var n uint64
+	if len(existing) == 8 {
+		n = binary.BigEndian.Uint64(existing)
+	}
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint64(out, n+binary.BigEndian.Uint64(operand))
+	return out
+}
+func (addOperator) Name() string { return "test.Add" }
+
+// appendOperator appends operands to the existing value, so the order they're
+// applied in matters.
+type appendOperator struct{}
+
+func (appendOperator) Merge(key, existing, operand []byte) []byte {
+	return append(append([]byte{}, existing...), operand...)
+}
+func (appendOperator) Name() string { return "test.Append" }
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func TestMerge(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 20
+
+	c := DefaultConfig
+	c.MergeOperator = addOperator{}
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("counter")
+	if err := db.Put(k, uint64Bytes(100)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if err := db.Merge(k, uint64Bytes(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	middle := db.now()
+	for i := 0; i < count; i++ {
+		if err := db.Merge(k, uint64Bytes(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func() {
+		if v, _ := db.Get(k); !bytes.Equal(v, uint64Bytes(100+2*count)) {
+			t.Fatalf("db.Get(%q) = %x; not %x", k, v, uint64Bytes(100+2*count))
+		}
+		if v, _ := db.GetAt(k, middle); !bytes.Equal(v, uint64Bytes(100+count)) {
+			t.Fatalf("db.GetAt(%q) = %x; not %x", k, v, uint64Bytes(100+count))
+		}
+		versions := db.History(k, zeroTime, zeroTime)
+		if len(versions) != 2*count+1 {
+			t.Fatalf("len(db.History(%q)) = %d; not %d", k, len(versions), 2*count+1)
+		}
+		if !bytes.Equal(versions[0].Value, uint64Bytes(100+2*count)) {
+			t.Fatalf("versions[0].Value = %x; not %x", versions[0].Value, uint64Bytes(100+2*count))
+		}
+	}
+	check()
+
+	// Force consolidation which collapses operands into full values.
+	db.consolidate(rootPage)
+	p := db.getPage(rootPage).next.getPage()
+	if len(p.keys) != 1 || hasMerge(p.keys[0].values) {
+		t.Fatalf("page keys = %+v; expected operands to be collapsed", p.keys)
+	}
+	check()
+}
+
+// TestMergeTxnNoConflict tests that concurrent merges in transactions don't
+// conflict.
+func TestMergeTxnNoConflict(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.MergeOperator = addOperator{}
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("counter")
+	txn := db.NewTxn()
+	txn2 := db.NewTxn()
+	if err := txn.Merge(k, uint64Bytes(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Merge(k, uint64Bytes(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Merge(k, uint64Bytes(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get(k); !bytes.Equal(v, uint64Bytes(7)) {
+		t.Fatalf("db.Get(%q) = %x; not %x", k, v, uint64Bytes(7))
+	}
+}
+
+// TestMergeOrder tests that operands are applied in timestamp order, both
+// before and after consolidation, even when a transaction commits its operand
+// after an operand that's above it in the delta chain.
+func TestMergeOrder(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.MaxDeltaCount = 1
+	c.MergeOperator = appendOperator{}
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("key")
+	txn := db.NewTxn()
+	if err := txn.Merge(k, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Merge(k, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("ba")
+	if v, _ := db.Get(k); !bytes.Equal(v, want) {
+		t.Fatalf("db.Get(%q) = %q; not %q", k, v, want)
+	}
+
+	// Force consolidation.
+	db.consolidate(rootPage)
+
+	if v, _ := db.Get(k); !bytes.Equal(v, want) {
+		t.Fatalf("db.Get(%q) = %q after consolidation; not %q", k, v, want)
+	}
+}
+
+func TestMergeNoOperator(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Merge([]byte("a"), []byte("b")); err != ErrNoMergeOperator {
+		t.Fatalf("db.Merge() = %v; not %v", err, ErrNoMergeOperator)
+	}
+}
@@ -0,0 +1,294 @@
+package skeleton
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// reservedPrefix is the prefix of the names of namespaces used internally.
+const reservedPrefix = "\x00"
+
+// ErrReservedNamespace is returned when opening a namespace with a name that is
+// reserved for internal use.
+var ErrReservedNamespace = errors.New("namespace names starting with a null byte are reserved")
+
+// ErrNamespaceConfig is returned when opening an existing namespace with a
+// config that differs from the one it was created with.
+var ErrNamespaceConfig = errors.New("the config differs from the namespace's config")
+
+// Namespace is a separate key space within a DB. Each namespace is its own
+// tree of pages in the DB's mapping table, so it can have its own
+// configuration, while transactions can span multiple namespaces.
+type Namespace struct {
+	db     *DB
+	name   string
+	root   pageID
+	config Config
+	// cmp holds the *Comparator that the namespace is ordered by. It's nil for
+	// a namespace read from the page store with a comparator the DB doesn't
+	// know until the namespace is opened with it, and is only set once.
+	cmp unsafe.Pointer
+	// cmpName is the name of the comparator that the namespace was written
+	// with, if it was read from the page store.
+	cmpName string
+	// indexes holds a *[]*index of the secondary indexes on the namespace.
+	indexes unsafe.Pointer
+}
+
+// NamespaceTxn is a view of a transaction that reads and writes the keys of a
+// namespace.
+type NamespaceTxn struct {
+	*Txn
+	ns *Namespace
+}
+
+// Namespace returns the namespace with the specified name, creating it if it
+// doesn't exist. The options of c that are set override MaxKeysPerNode,
+// MaxDeltaCount, GCTime and Comparator of the DB's config for that namespace.
+// The other options are shared by the whole DB. If c is nil, the DB's config is
+// used. If the namespace already exists, ErrNamespaceConfig is returned when c
+// differs from the config it was created with.
+// The empty name refers to the default namespace that DB operates on.
+func (db *DB) Namespace(name string, c *Config) (*Namespace, error) {
+	if strings.HasPrefix(name, reservedPrefix) {
+		return nil, ErrReservedNamespace
+	}
+	return db.namespace(name, c)
+}
+
+func (db *DB) namespace(name string, c *Config) (*Namespace, error) {
+	existing := db.ns
+	if name != "" {
+		existing = db.getNamespace(name)
+	}
+	if existing != nil {
+		if err := existing.checkConfig(c); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	config, err := db.namespaceConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	ns := &Namespace{
+		db:     db,
+		name:   name,
+		root:   db.nextPageID(),
+		config: config,
+	}
+	ns.setComparator(config.comparator())
+	// This set doesn't have to be atomic since this ID hasn't been used yet.
+	db.getPage(ns.root).next = &delta{
+		page: &page{id: ns.root, ns: ns},
+	}
+	db.addUsage(chainUsage(db.getPage(ns.root).next))
+
+	for {
+		old := atomic.LoadPointer(&db.namespaces)
+		namespaces := map[string]*Namespace{}
+		if old != nil {
+			for k, v := range *(*map[string]*Namespace)(old) {
+				namespaces[k] = v
+			}
+		}
+		if existing, ok := namespaces[name]; ok {
+			// Lost the race to create the namespace.
+			db.addUsage(usage{}.minus(chainUsage(db.getPage(ns.root).next)))
+			select {
+			case db.pageIDPool <- ns.root:
+			default:
+			}
+			return existing, nil
+		}
+		namespaces[name] = ns
+		if atomic.CompareAndSwapPointer(&db.namespaces, old, unsafe.Pointer(&namespaces)) {
+			return ns, nil
+		}
+	}
+}
+
+// namespaceConfig returns the DB's config with the options of c that can be
+// set per namespace, and are set, overriding it.
+func (db *DB) namespaceConfig(c *Config) (Config, error) {
+	config := db.config
+	if c == nil {
+		return config, nil
+	}
+	if c.MaxKeysPerNode != 0 {
+		config.MaxKeysPerNode = c.MaxKeysPerNode
+	}
+	if c.MaxDeltaCount != 0 {
+		config.MaxDeltaCount = c.MaxDeltaCount
+	}
+	if c.GCTime != 0 {
+		config.GCTime = c.GCTime
+	}
+	if c.Comparator != nil {
+		config.Comparator = c.Comparator
+	}
+	if err := config.Verify(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// checkConfig returns an error if c differs from the config the namespace was
+// created with.
+func (ns *Namespace) checkConfig(c *Config) error {
+	if err := ns.checkComparator(c); err != nil {
+		return err
+	}
+	if c == nil {
+		return nil
+	}
+	config, err := ns.db.namespaceConfig(c)
+	if err != nil {
+		return err
+	}
+	if config.MaxKeysPerNode != ns.config.MaxKeysPerNode ||
+		config.MaxDeltaCount != ns.config.MaxDeltaCount ||
+		config.GCTime != ns.config.GCTime {
+		return ErrNamespaceConfig
+	}
+	return nil
+}
+
+// checkComparator returns ErrComparatorMismatch if c has a different
+// comparator than the namespace. A namespace read from the page store with a
+// comparator the DB doesn't know takes the comparator of the first config that
+// opens it with one of the same name.
+func (ns *Namespace) checkComparator(c *Config) error {
+	cmp := ns.db.config.comparator()
+	if c != nil && c.Comparator != nil {
+		cmp = c.Comparator
+	} else if ns.comparator() != nil {
+		return nil
+	}
+	if cmp.Name() == ns.cmpName {
+		ns.setComparator(cmp)
+	}
+	if existing := ns.comparator(); existing == nil || existing.Name() != cmp.Name() {
+		return ErrComparatorMismatch
+	}
+	return nil
+}
+
+// comparator returns the comparator of the namespace, or nil if it hasn't been
+// opened with it yet.
+func (ns *Namespace) comparator() Comparator {
+	cmp := (*Comparator)(atomic.LoadPointer(&ns.cmp))
+	if cmp == nil {
+		return nil
+	}
+	return *cmp
+}
+
+// setComparator sets the comparator of the namespace, unless it already has
+// one.
+func (ns *Namespace) setComparator(cmp Comparator) {
+	atomic.CompareAndSwapPointer(&ns.cmp, nil, unsafe.Pointer(&cmp))
+}
+
+// getNamespace returns the named namespace or nil if it doesn't exist.
+func (db *DB) getNamespace(name string) *Namespace {
+	namespaces := (*map[string]*Namespace)(atomic.LoadPointer(&db.namespaces))
+	if namespaces == nil {
+		return nil
+	}
+	return (*namespaces)[name]
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Get gets a value from the namespace.
+func (ns *Namespace) Get(key []byte) ([]byte, bool) {
+	return ns.db.getAt(ns, nil, key, zeroTime)
+}
+
+// GetAt gets a value from the namespace at the specified time.
+func (ns *Namespace) GetAt(key []byte, at time.Time) ([]byte, bool) {
+	at = ns.db.snapshot(at)
+	return ns.db.getAt(ns, nil, key, at)
+}
+
+// Put writes a value into the namespace.
+func (ns *Namespace) Put(k, v []byte) error {
+	if ns.indexed() {
+		return ns.Txn(func(txn NamespaceTxn) error {
+			return txn.Put(k, v)
+		})
+	}
+	return ns.db.put(ns, nil, k, v)
+}
+
+// Delete removes a value from the namespace.
+func (ns *Namespace) Delete(k []byte) error {
+	if ns.indexed() {
+		return ns.Txn(func(txn NamespaceTxn) error {
+			return txn.Delete(k)
+		})
+	}
+	return ns.db.delete(ns, nil, k)
+}
+
+// NewTxn creates a new transaction on the namespace.
+func (ns *Namespace) NewTxn() NamespaceTxn {
+	return ns.db.NewTxn().In(ns)
+}
+
+// Txn runs f in a transaction on the namespace. See DB.Txn.
+func (ns *Namespace) Txn(f func(NamespaceTxn) error) error {
+	return ns.db.Txn(func(txn *Txn) error {
+		return f(txn.In(ns))
+	})
+}
+
+// Put writes a value into the namespace.
+func (t NamespaceTxn) Put(k, v []byte) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	return t.updateIndexes(k, func() error {
+		return t.db.put(t.ns, t.Txn, k, v)
+	})
+}
+
+// Delete removes a value from the namespace.
+func (t NamespaceTxn) Delete(k []byte) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	return t.updateIndexes(k, func() error {
+		return t.db.delete(t.ns, t.Txn, k)
+	})
+}
+
+// Get gets a value from the namespace.
+func (t NamespaceTxn) Get(key []byte) ([]byte, bool) {
+	if t.readOnly {
+		return t.db.getAt(t.ns, nil, key, t.time)
+	}
+	return t.db.getAt(t.ns, t.Txn, key, zeroTime)
+}
+
+// GetAt gets a value from the namespace at the specified time. Read-only
+// transactions read their snapshot if at is the zero time.
+func (t NamespaceTxn) GetAt(key []byte, at time.Time) ([]byte, bool) {
+	if t.readOnly {
+		if isZeroTime(at) {
+			at = t.time
+		}
+		at = t.db.snapshot(at)
+		return t.db.getAt(t.ns, nil, key, at)
+	}
+	return t.db.getAt(t.ns, t.Txn, key, at)
+}
@@ -0,0 +1,213 @@
+package skeleton
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestNamespace(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, err := db.Namespace("a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.Namespace("b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a2, _ := db.Namespace("a", nil); a2 != a {
+		t.Fatalf("db.Namespace(a) = %p; not %p", a2, a)
+	}
+	if ns, _ := db.Namespace("", nil); ns != db.ns {
+		t.Fatalf("db.Namespace(\"\") = %p; not the default namespace", ns)
+	}
+
+	k := []byte("key")
+	if err := a.Put(k, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(k, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := a.Get(k); !bytes.Equal(v, []byte("a")) {
+		t.Fatalf("a.Get(%q) = %q; not %q", k, v, "a")
+	}
+	if v, _ := b.Get(k); !bytes.Equal(v, []byte("b")) {
+		t.Fatalf("b.Get(%q) = %q; not %q", k, v, "b")
+	}
+	if v, ok := db.Get(k); ok {
+		t.Fatalf("db.Get(%q) = %q; should not be ok", k, v)
+	}
+
+	if err := a.Delete(k); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := a.Get(k); v != nil {
+		t.Fatalf("a.Get(%q) = %q; not nil", k, v)
+	}
+	if v, _ := b.Get(k); !bytes.Equal(v, []byte("b")) {
+		t.Fatalf("b.Get(%q) = %q; not %q", k, v, "b")
+	}
+
+	// Namespaces of other databases can't be used.
+	other, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	c, _ := other.Namespace("c", nil)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("txn.In(c) should panic")
+			}
+		}()
+		db.NewTxn().In(c)
+	}()
+}
+
+// TestNamespaceTxn tests that transactions can span multiple namespaces.
+func TestNamespaceTxn(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, _ := db.Namespace("a", nil)
+	b, _ := db.Namespace("b", nil)
+	k := []byte("key")
+
+	txn := db.NewTxn()
+	if err := txn.In(a).Put(k, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.In(b).Put(k, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := txn.In(b).Get(k); !bytes.Equal(v, []byte("b")) {
+		t.Fatalf("txn.In(b).Get(%q) = %q; not %q", k, v, "b")
+	}
+	if v, ok := a.Get(k); ok {
+		t.Fatalf("a.Get(%q) = %q; should not be ok", k, v)
+	}
+	if err := txn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Txn(func(txn NamespaceTxn) error {
+		if err := txn.Put(k, []byte("a")); err != nil {
+			return err
+		}
+		return txn.In(b).Put(k, []byte("b"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := a.Get(k); !bytes.Equal(v, []byte("a")) {
+		t.Fatalf("a.Get(%q) = %q; not %q", k, v, "a")
+	}
+	if v, _ := b.Get(k); !bytes.Equal(v, []byte("b")) {
+		t.Fatalf("b.Get(%q) = %q; not %q", k, v, "b")
+	}
+}
+
+// TestNamespaceConfig tests that namespaces can override the page
+// configuration.
+func TestNamespaceConfig(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 50
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	small, err := db.Namespace("small", &Config{MaxKeysPerNode: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Namespace("bad", &Config{MaxKeysPerNode: -1}); err == nil {
+		t.Fatal("expected db.Namespace with an invalid config to throw an error")
+	}
+	// The options that aren't set in an override come from the DB's config.
+	if got, want := small.config.MaxDeltaCount, DefaultConfig.MaxDeltaCount; got != want {
+		t.Fatalf("small.config.MaxDeltaCount = %d; not %d", got, want)
+	}
+	if _, err := db.Namespace("small", &Config{MaxKeysPerNode: 20}); err != ErrNamespaceConfig {
+		t.Fatalf("db.Namespace with a different config = %v; not %v", err, ErrNamespaceConfig)
+	}
+	if ns, err := db.Namespace("small", &Config{MaxKeysPerNode: 10}); err != nil || ns != small {
+		t.Fatalf("db.Namespace with the same config = %p, %v; not %p", ns, err, small)
+	}
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+		if err := small.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Force consolidation and splitting
+	for _, root := range []pageID{rootPage, small.root} {
+		db.consolidate(root)
+		db.split(root)
+	}
+
+	if p := db.getPage(rootPage).next.getPage(); p.key != nil {
+		t.Fatalf("default namespace root should not be split")
+	}
+	if p := db.getPage(small.root).next.getPage(); p.key == nil {
+		t.Fatalf("small namespace root should be split")
+	}
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if v, _ := small.Get(k); !bytes.Equal(v, k) {
+			t.Errorf("small.Get(%q) = %q; not %q", k, v, k)
+		}
+	}
+}
+
+func TestNamespaceComparator(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := DefaultConfig
+	c.Comparator = caseInsensitiveComparator{}
+	ns, err := db.Namespace("ci", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Put([]byte("a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ns.Get([]byte("A")); !ok {
+		t.Errorf("ns.Get(%q) should use the namespace comparator", "A")
+	}
+	if _, ok := db.Get([]byte("A")); ok {
+		t.Errorf("db.Get(%q) should use the default comparator", "A")
+	}
+}
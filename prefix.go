@@ -0,0 +1,127 @@
+package skeleton
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// compressPrefix moves the prefix shared by every key of a consolidated data
+// page into p.prefix, and copies what's left of each key into a single buffer.
+// The keys must be whole and owned by the page, since they're modified.
+func (p *page) compressPrefix() {
+	p.prefix = nil
+	if len(p.keys) == 0 {
+		return
+	}
+	prefix := p.keys[0].key
+	size := 0
+	for _, k := range p.keys {
+		n := 0
+		for n < len(prefix) && n < len(k.key) && prefix[n] == k.key[n] {
+			n++
+		}
+		prefix = prefix[:n]
+		size += len(k.key)
+	}
+	if len(prefix) > 0 {
+		p.prefix = append([]byte{}, prefix...)
+	}
+	buf := make([]byte, 0, size-len(prefix)*len(p.keys))
+	for _, k := range p.keys {
+		start := len(buf)
+		buf = append(buf, k.key[len(prefix):]...)
+		k.key = buf[start:len(buf):len(buf)]
+	}
+}
+
+// keyAt returns the whole key at i in the page.
+func (p *page) keyAt(i int) []byte {
+	k := p.keys[i].key
+	if len(p.prefix) == 0 {
+		return k
+	}
+	return p.appendKey(make([]byte, 0, len(p.prefix)+len(k)), i)
+}
+
+// appendKey appends the whole key at i in the page to buf.
+func (p *page) appendKey(buf []byte, i int) []byte {
+	return append(append(buf, p.prefix...), p.keys[i].key...)
+}
+
+// keysAt returns the whole keys in [i, j) of the page. If the page has a
+// prefix, the keys are rebuilt in a single buffer rather than one each.
+func (p *page) keysAt(i, j int) [][]byte {
+	keys := make([][]byte, 0, j-i)
+	if len(p.prefix) == 0 {
+		for ; i < j; i++ {
+			keys = append(keys, p.keys[i].key)
+		}
+		return keys
+	}
+	size := 0
+	for _, k := range p.keys[i:j] {
+		size += len(p.prefix) + len(k.key)
+	}
+	buf := make([]byte, 0, size)
+	for ; i < j; i++ {
+		start := len(buf)
+		buf = p.appendKey(buf, i)
+		keys = append(keys, buf[start:len(buf):len(buf)])
+	}
+	return keys
+}
+
+// keyBuffers holds *[]byte buffers that keys are rebuilt in to be compared.
+var keyBuffers = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// compareKey compares the key at i in the page with k. Keys are only rebuilt
+// for comparators other than BytewiseComparator, since the order of the
+// suffixes is the order of the keys, and they're rebuilt in a pooled buffer so
+// comparing doesn't allocate.
+func (p *page) compareKey(cmp Comparator, i int, k []byte) int {
+	suffix := p.keys[i].key
+	if len(p.prefix) == 0 {
+		return cmp.Compare(suffix, k)
+	}
+	if _, ok := cmp.(bytewiseComparator); !ok {
+		buf := keyBuffers.Get().(*[]byte)
+		*buf = p.appendKey((*buf)[:0], i)
+		c := cmp.Compare(*buf, k)
+		keyBuffers.Put(buf)
+		return c
+	}
+	n := len(p.prefix)
+	if len(k) < n {
+		if c := bytes.Compare(p.prefix[:len(k)], k); c != 0 {
+			return c
+		}
+		return 1
+	}
+	if c := bytes.Compare(p.prefix, k[:n]); c != 0 {
+		return c
+	}
+	return bytes.Compare(suffix, k[n:])
+}
+
+// search returns the index of the first key in the page that isn't before k.
+func (p *page) search(cmp Comparator, k []byte) int {
+	return p.searchFrom(cmp, 0, k)
+}
+
+// searchFrom returns the number of keys from i in the page that are before k.
+func (p *page) searchFrom(cmp Comparator, i int, k []byte) int {
+	return sort.Search(len(p.keys)-i, func(n int) bool {
+		return p.compareKey(cmp, i+n, k) >= 0
+	})
+}
+
+// find returns the key k in the page, or nil if the page doesn't have it.
+func (p *page) find(cmp Comparator, k []byte) *key {
+	if i := p.search(cmp, k); i < len(p.keys) && p.compareKey(cmp, i, k) == 0 {
+		return p.keys[i]
+	}
+	return nil
+}
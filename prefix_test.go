@@ -0,0 +1,124 @@
+package skeleton
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestCompressPrefix(t *testing.T) {
+	testCases := []struct {
+		cmp    Comparator
+		keys   []string
+		prefix string
+	}{
+		{BytewiseComparator, nil, ""},
+		{BytewiseComparator, []string{"a", "b"}, ""},
+		{BytewiseComparator, []string{"tenant/1/a", "tenant/1/b", "tenant/12"}, "tenant/1"},
+		{BytewiseComparator, []string{"tenant", "tenant/1"}, "tenant"},
+		{reverseComparator{}, []string{"tenant/2", "tenant/1"}, "tenant/"},
+		{caseInsensitiveComparator{}, []string{"Key/a", "Key/B", "KEY/c"}, "K"},
+	}
+	probes := []string{"", "a", "b", "c", "t", "tenant", "tenant/", "tenant/0", "tenant/1", "tenant/1/a", "tenant/1/aa", "tenant/12", "tenant/2", "tenant/3", "u", "K", "KEY", "Key/b", "key/a", "key/z"}
+	for i, tc := range testCases {
+		p := &page{}
+		for _, k := range tc.keys {
+			p.keys = append(p.keys, &key{key: []byte(k)})
+		}
+		p.compressPrefix()
+		if string(p.prefix) != tc.prefix || (tc.prefix == "") != (p.prefix == nil) {
+			t.Errorf("%d: p.prefix = %q; not %q", i, p.prefix, tc.prefix)
+		}
+		var keys []string
+		for j := range p.keys {
+			keys = append(keys, string(p.keyAt(j)))
+		}
+		if !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("%d: keys = %q; not %q", i, keys, tc.keys)
+		}
+		keys = nil
+		for _, k := range p.keysAt(0, len(p.keys)) {
+			keys = append(keys, string(k))
+		}
+		if !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("%d: p.keysAt = %q; not %q", i, keys, tc.keys)
+		}
+		// caseInsensitiveComparator allocates itself.
+		if _, ok := tc.cmp.(caseInsensitiveComparator); !ok && len(p.keys) > 0 {
+			k := []byte("key/a")
+			if allocs := testing.AllocsPerRun(100, func() { p.compareKey(tc.cmp, 0, k) }); allocs > 0 {
+				t.Errorf("%d: p.compareKey made %.0f allocations; not 0", i, allocs)
+			}
+		}
+
+		for _, probe := range probes {
+			k := []byte(probe)
+			want := len(p.keys)
+			for j := range p.keys {
+				if c := tc.cmp.Compare(p.keyAt(j), k); c >= 0 {
+					if want == len(p.keys) {
+						want = j
+					}
+				}
+				if c, wantC := p.compareKey(tc.cmp, j, k), tc.cmp.Compare(p.keyAt(j), k); c != wantC {
+					t.Errorf("%d: p.compareKey(%d, %q) = %d; not %d", i, j, k, c, wantC)
+				}
+			}
+			if got := p.search(tc.cmp, k); got != want {
+				t.Errorf("%d: p.search(%q) = %d; not %d", i, k, got, want)
+			}
+			found := p.find(tc.cmp, k)
+			if wantFound := want < len(p.keys) && tc.cmp.Compare(p.keyAt(want), k) == 0; (found != nil) != wantFound {
+				t.Errorf("%d: p.find(%q) = %+v; found should be %t", i, k, found, wantFound)
+			}
+		}
+	}
+}
+
+func TestPrefixCompression(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	c := DefaultConfig
+	c.MaxKeysPerNode = 20
+	c.MaxDeltaCount = 1
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var size int64
+	for i := 0; i < count; i++ {
+		k := []byte(fmt.Sprintf("tenant/123/orders/%03d", i))
+		size += int64(len(k))
+		if err := db.Put(k, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	splitAll(db)
+	consolidateAll(db)
+
+	for i := 0; i < count; i++ {
+		k := []byte(fmt.Sprintf("tenant/123/orders/%03d", i))
+		if v, ok := db.Get(k); !ok || v == nil {
+			t.Errorf("db.Get(%q) = %q, %t; should be found", k, v, ok)
+		}
+	}
+	if v, ok := db.Get([]byte("tenant/123/orders/")); ok {
+		t.Errorf("db.Get(prefix) = %q; should not be found", v)
+	}
+	var keys [][]byte
+	for it := db.ScanPrefix([]byte("tenant/123/orders/01")); it.Next(); {
+		keys = append(keys, it.Key())
+	}
+	if len(keys) != 10 || !bytes.Equal(keys[0], []byte("tenant/123/orders/010")) {
+		t.Errorf("db.ScanPrefix = %q; not orders 010 to 019", keys)
+	}
+	if keysSize := db.Stats().Keys - count*keySize; keysSize >= size/2 {
+		t.Errorf("db.Stats().Keys = %d bytes of keys; should be less than half of %d", keysSize, size)
+	}
+}
@@ -1,119 +1,207 @@
 package skeleton
 
 import (
-	"bytes"
 	"log"
 	"sort"
+	"time"
 )
 
 // workerLoop process the various queues.
 func (db *DB) workerLoop() {
+	defer db.workers.Done()
+	var checkpoints <-chan time.Time
+	if db.store != nil && db.config.CheckpointInterval > 0 {
+		ticker := time.NewTicker(db.config.CheckpointInterval)
+		defer ticker.Stop()
+		checkpoints = ticker.C
+	}
 	for {
 		select {
 		case <-db.closed:
 			return
+		case <-checkpoints:
+			db.backgroundCheckpoint()
 		case id := <-db.splitQueue:
 			db.split(id)
 		case id := <-db.consolidateQueue:
 			db.consolidate(id)
+		case <-db.evictQueue:
+			db.evict()
 		}
 	}
 }
 
-// consolidate consolidates deltas for that page.
+// consolidate consolidates deltas for that page. If the page can't be read
+// from the page store, the error is logged and the page is left as is.
 func (db *DB) consolidate(id pageID) {
-	var newPage page
+	var newPage *page
 	for {
-		root := db.getPage(id).next
+		root, err := db.getChain(id)
+		if err != nil {
+			log.Printf("consolidate %+v: %+v", id, err)
+			return
+		}
+		ns := root.getPage().ns
 
 		// Count deltas to ensure that we don't do unnecessary work.
 		deltaCount := root.deltaCount()
-		if deltaCount <= db.config.MaxDeltaCount {
+		if deltaCount <= ns.config.MaxDeltaCount {
 			return
 		}
 		log.Printf("consolidate %+v: start", id)
 
-		// Build slice of deltas sorted by their keys.
-		var page *page
-		var keys []*key
-		// Also keep track of deltas that can't be merged.
 		var head, tail *delta
+		newPage, head, tail = db.consolidateChain(root, zeroTime)
+		db.flush(newPage)
 
-		for d := root; d != nil; d = d.next {
-			if d.key != nil {
-				// This does some subtle things with transactions.
-				// - Merge committed transactions.
-				// - Keep pending transactions as deltas for easier cleanup.
-				// - Discard aborted transactions.
-				// - Discard read intents.
-				if d.key.txn == nil || d.key.txn.status == StatusCommitted {
-					if !d.key.read {
-						keys = append(keys, d.key)
-					}
-				} else if d.key.txn.status == StatusPending {
-					d2 := d.clone()
-					d2.next = nil
-					if tail != nil {
-						tail.next = d2
-					}
-					if head == nil {
-						head = d2
-					}
-					tail = d2
-				}
+		newRoot := &delta{page: newPage}
+		if head == nil {
+			head = newRoot
+		} else {
+			tail.next = newRoot
+		}
+		if db.savePageNext(id, root, head) {
+			db.addUsage(chainUsage(head).minus(chainUsage(root)))
+			log.Printf("consolidate %+v: finish, merged %d, key count = %d", id, deltaCount, len(newPage.keys))
+			break
+		}
+		log.Printf("consolidate %+v: conflict, retrying", id)
+	}
+	db.maybeQueueSplit(*newPage)
+}
+
+// consolidateChain merges the committed deltas of a data page's delta chain
+// into a new page. The deltas of pending and prepared transactions can't be
+// merged yet, so they're returned as a chain to keep on top of the page. Unless
+// at is the zero time, deltas committed after it are left out of both.
+func (db *DB) consolidateChain(root *delta, at time.Time) (*page, *delta, *delta) {
+	// Build slice of deltas sorted by their keys.
+	var page *page
+	var keys, ranges []*key
+	// Also keep track of deltas that can't be merged.
+	var head, tail *delta
+
+	for d := root; d != nil; d = d.next {
+		if d.key != nil {
+			// This does some subtle things with transactions.
+			// - Merge committed transactions, stamped with their commit
+			//   timestamp.
+			// - Keep pending and prepared transactions as deltas for easier
+			//   cleanup.
+			// - Discard aborted transactions.
+			// - Discard writes undone by rolling back to a savepoint.
+			// - Discard read intents.
+			if d.key.rolledBack() {
+				continue
 			}
-			if d.page != nil {
-				page = d.page
+			if d.key.committed() {
+				if !d.key.committedBy(at) {
+					continue
+				}
+				if d.key.end != nil {
+					ranges = append(ranges, d.key.stamp())
+				} else if !d.key.read {
+					keys = append(keys, d.key.stamp())
+				}
+			} else if d.key.txn.Status().active() {
+				head, tail = appendDelta(head, tail, d)
 			}
 		}
-		sort.Sort(byKey(keys))
-
-		if page.key != nil {
-			panic("invariant: index node must not have deltas")
+		if d.page != nil {
+			page = d.page
 		}
+	}
+	ns := page.ns
+	sort.Sort(byKey{keys, ns.comparator()})
 
-		// TODO(d4l3k): Optimize memory allocations and copies.
-		newPage = *page
-		newPage.keys = make([]*key, 0, len(page.keys)+len(keys))
-		for i, j := 0, 0; i < len(page.keys) || j < len(keys); {
-			if i < len(page.keys) && (j >= len(keys) || bytes.Compare(page.keys[i].key, keys[j].key) <= 0) {
-				newKey := page.keys[i].clone()
+	if page.key != nil {
+		panic("invariant: index node must not have deltas")
+	}
+
+	// TODO(d4l3k): Optimize memory allocations and copies.
+	newPage := *page
+	newPage.offset = 0
+	newPage.keys = make([]*key, 0, len(page.keys)+len(keys))
+	for i, j := 0, 0; i < len(page.keys) || j < len(keys); {
+		if i < len(page.keys) && (j >= len(keys) || page.compareKey(ns.comparator(), i, keys[j].key) <= 0) {
+			newKey := page.keys[i].clone()
+			newKey.key = page.keyAt(i)
+			newPage.keys = append(newPage.keys, &newKey)
+			i++
+		} else if j < len(keys) {
+			b := keys[j]
+			var prevKey *key
+			if len(newPage.keys) > 0 {
+				prevKey = newPage.keys[len(newPage.keys)-1]
+			}
+			if prevKey != nil && ns.comparator().Compare(b.key, prevKey.key) == 0 {
+				prevKey.values = append(append([]value{}, b.values...), prevKey.values...)
+			} else {
+				newKey := b.clone()
 				newPage.keys = append(newPage.keys, &newKey)
-				i++
-			} else if j < len(keys) {
-				b := keys[j]
-				var prevKey *key
-				if len(newPage.keys) > 0 {
-					prevKey = newPage.keys[len(newPage.keys)-1]
-				}
-				if prevKey != nil && bytes.Equal(b.key, prevKey.key) {
-					prevKey.values = append(append([]value{}, b.values...), prevKey.values...)
-				} else {
-					newKey := b.clone()
-					newPage.keys = append(newPage.keys, &newKey)
-				}
-				j++
 			}
+			j++
 		}
+	}
 
-		newRoot := &delta{page: &newPage}
-		if head == nil {
-			head = newRoot
-		} else {
-			tail.next = newRoot
+	newPage.keys = db.foldRanges(ns, newPage.keys, ranges)
+	for _, k := range newPage.keys {
+		if hasMerge(k.values) {
+			db.collapseMerges(k.key, k.values)
 		}
-		if db.savePageNext(id, root, head) {
-			log.Printf("consolidate %+v: finish, merged %d, key count = %d", id, deltaCount, len(newPage.keys))
-			break
+	}
+	newPage.compressPrefix()
+	newPage.updateCounts()
+	return &newPage, head, tail
+}
+
+// foldRanges folds range tombstones into tombstones on each of the keys they
+// cover. If a range tombstone is older than GCTime, the versions it deleted are
+// garbage collected instead, and keys left without versions are dropped.
+func (db *DB) foldRanges(ns *Namespace, keys []*key, ranges []*key) []*key {
+	if len(ranges) == 0 {
+		return keys
+	}
+	horizon := db.now().Add(-ns.config.GCTime)
+	folded := keys[:0]
+	for _, k := range keys {
+		for _, r := range ranges {
+			if !r.covers(ns.comparator(), k.key) {
+				continue
+			}
+			tombstone := r.values[0]
+			if tombstone.time.Before(horizon) {
+				i := 0
+				for i < len(k.values) && k.values[i].time.After(tombstone.time) {
+					i++
+				}
+				k.values = k.values[:i]
+			} else {
+				k.values = insertValue(k.values, tombstone)
+			}
 		}
-		log.Printf("consolidate %+v: conflict, retrying", id)
+		if len(k.values) > 0 {
+			folded = append(folded, k)
+		}
+	}
+	return folded
+}
+
+// insertValue inserts v into values, which are ordered newest first.
+func insertValue(values []value, v value) []value {
+	i := 0
+	for i < len(values) && values[i].time.After(v.time) {
+		i++
 	}
-	db.maybeQueueSplit(newPage)
+	values = append(values, value{})
+	copy(values[i+1:], values[i:])
+	values[i] = v
+	return values
 }
 
 func (db *DB) maybeQueueSplit(p page) {
 	// Schedule node for splitting if it's too large.
-	if len(p.keys) > db.config.MaxKeysPerNode {
+	if len(p.keys) > p.ns.config.MaxKeysPerNode {
 		select {
 		case db.splitQueue <- p.id:
 		default:
@@ -121,59 +209,92 @@ func (db *DB) maybeQueueSplit(p page) {
 	}
 }
 
-// split splits a page.
+// split splits a data page. The root of a namespace is split by moving its
+// halves onto two new pages and turning it into an index node. Other data pages
+// are split in two steps like a B-link tree: halfSplit moves the upper half
+// onto a new right sibling, and postSplit then adds the separator to the
+// parent. In between, the page's high key and right link lead readers and
+// writers to the sibling. If a page can't be read from the page store, the
+// error is logged and the split is left for later.
 func (db *DB) split(id pageID) {
 	log.Printf("split %+v: scheduled", id)
+	d, err := db.getChain(id)
+	if err != nil {
+		log.Printf("split %+v: %+v", id, err)
+		return
+	}
+	ns := d.getPage().ns
+	if id == ns.root {
+		db.splitRoot(id)
+		return
+	}
+	right, midKey, ok := db.halfSplit(id)
+	if !ok {
+		return
+	}
+	if err := db.postSplit(ns, id, midKey, right); err != nil {
+		log.Printf("split %+v: %+v", id, err)
+		return
+	}
+	for _, id := range []pageID{id, right} {
+		if d, err := db.getChain(id); err == nil {
+			db.maybeQueueSplit(*d.getPage())
+		}
+	}
+}
+
+// splitRoot splits the root data page of a namespace.
+func (db *DB) splitRoot(id pageID) {
 	for {
-		root := db.getPage(id).next
+		root, err := db.getChain(id)
+		if err != nil {
+			log.Printf("split %+v: %+v", id, err)
+			return
+		}
 		p := root.getPage()
 		// Count keys to ensure that we don't do unnecessary work.
-		if len(p.keys) <= db.config.MaxKeysPerNode {
+		if len(p.keys) <= p.ns.config.MaxKeysPerNode {
 			return
 		}
 		log.Printf("split %+v: start, key count = %d", id, len(p.keys))
 
 		mid := len(p.keys) / 2
-		midKey := p.keys[mid].key
+		midKey := p.keyAt(mid)
 		left := page{
-			id:   db.nextPageID(),
-			keys: p.keys[:mid],
-		}
-		leftPage := &delta{
-			page: &left,
+			id:     db.nextPageID(),
+			ns:     p.ns,
+			keys:   p.keys[:mid],
+			prefix: p.prefix,
+			high:   midKey,
+			prev:   p.prev,
 		}
 		right := page{
-			id:   db.nextPageID(),
-			keys: p.keys[mid:],
-		}
-		rightPage := &delta{
-			page: &right,
+			id:     db.nextPageID(),
+			ns:     p.ns,
+			keys:   p.keys[mid:],
+			prefix: p.prefix,
+			high:   p.high,
+			prev:   left.id,
+			next:   p.next,
 		}
+		left.next = right.id
+		left.updateCounts()
+		right.updateCounts()
 		newPage := page{
 			id:    p.id,
+			ns:    p.ns,
 			key:   midKey,
 			left:  left.id,
 			right: right.id,
 		}
 
-		// Move any existing deltas onto the new children.
-		for d := root; d.next != nil; d = d.next {
-			d2 := d.clone()
-			if bytes.Compare(midKey, d2.key.key) <= 0 {
-				d2.next = rightPage
-				rightPage = d2
-			} else {
-				d2.next = leftPage
-				leftPage = d2
-			}
-		}
-
 		// These sets don't have to be atomic since these IDs haven't been used yet.
-		db.getPage(left.id).next = leftPage
-		db.getPage(right.id).next = rightPage
+		leftPage, rightPage := splitChain(p.ns.comparator(), root, midKey, &left, &right)
+		db.getPage(left.id).next, db.getPage(right.id).next = leftPage, rightPage
 
 		newRoot := &delta{page: &newPage}
 		if db.savePageNext(id, root, newRoot) {
+			db.addUsage(chainUsage(newRoot).plus(chainUsage(leftPage)).plus(chainUsage(rightPage)).minus(chainUsage(root)))
 			log.Printf("split %+v: finish", id)
 			db.maybeQueueSplit(left)
 			db.maybeQueueSplit(right)
@@ -184,3 +305,140 @@ func (db *DB) split(id pageID) {
 		db.pageIDPool <- right.id
 	}
 }
+
+// halfSplit moves the upper half of a data page onto a new right sibling, and
+// returns the ID of the sibling and the separating key.
+func (db *DB) halfSplit(id pageID) (pageID, []byte, bool) {
+	for {
+		root, err := db.getChain(id)
+		if err != nil {
+			log.Printf("split %+v: %+v", id, err)
+			return 0, nil, false
+		}
+		p := root.getPage()
+		// Count keys to ensure that we don't do unnecessary work.
+		if len(p.keys) <= p.ns.config.MaxKeysPerNode {
+			return 0, nil, false
+		}
+		log.Printf("split %+v: start, key count = %d", id, len(p.keys))
+
+		mid := len(p.keys) / 2
+		midKey := p.keyAt(mid)
+		right := page{
+			id:     db.nextPageID(),
+			ns:     p.ns,
+			keys:   p.keys[mid:],
+			prefix: p.prefix,
+			high:   p.high,
+			prev:   id,
+			next:   p.next,
+		}
+		left := page{
+			id:     id,
+			ns:     p.ns,
+			keys:   p.keys[:mid],
+			prefix: p.prefix,
+			high:   midKey,
+			prev:   p.prev,
+			next:   right.id,
+		}
+		left.updateCounts()
+		right.updateCounts()
+
+		leftPage, rightPage := splitChain(p.ns.comparator(), root, midKey, &left, &right)
+		// This set doesn't have to be atomic since the ID hasn't been used yet.
+		db.getPage(right.id).next = rightPage
+
+		if db.savePageNext(id, root, leftPage) {
+			db.addUsage(chainUsage(leftPage).plus(chainUsage(rightPage)).minus(chainUsage(root)))
+			log.Printf("split %+v: half split, right sibling %+v", id, right.id)
+			return right.id, midKey, true
+		}
+		log.Printf("split %+v: conflict, retrying", id)
+		db.pageIDPool <- right.id
+	}
+}
+
+// postSplit replaces the pointer to a data page that has been half split in
+// its parent with an index node that separates it from its right sibling.
+// Until it's posted, the right sibling is still reached through the page.
+func (db *DB) postSplit(ns *Namespace, id pageID, midKey []byte, right pageID) error {
+	for {
+		// Find the parent by following the separating key, which is in the range
+		// of the page until the split is posted.
+		parentID := ns.root
+		parent, err := db.getChain(parentID)
+		if err != nil {
+			return err
+		}
+		var isLeft bool
+		for {
+			if parent.page == nil || parent.page.key == nil {
+				panic("invariant: half split page must have a parent")
+			}
+			child := parent.page.right
+			isLeft = ns.comparator().Compare(midKey, parent.page.key) < 0
+			if isLeft {
+				child = parent.page.left
+			}
+			if child == id {
+				break
+			}
+			parentID = child
+			if parent, err = db.getChain(child); err != nil {
+				return err
+			}
+		}
+
+		node := page{
+			id:    db.nextPageID(),
+			ns:    ns,
+			key:   midKey,
+			left:  id,
+			right: right,
+		}
+		// This set doesn't have to be atomic since the ID hasn't been used yet.
+		db.getPage(node.id).next = &delta{page: &node}
+
+		newParent := *parent.page
+		newParent.offset = 0
+		if isLeft {
+			newParent.left = node.id
+		} else {
+			newParent.right = node.id
+		}
+		if db.savePageNext(parentID, parent, &delta{page: &newParent}) {
+			db.addUsage(chainUsage(db.getPage(node.id).next))
+			log.Printf("split %+v: finish", id)
+			return nil
+		}
+		log.Printf("split %+v: conflict posting to parent, retrying", id)
+		db.pageIDPool <- node.id
+	}
+}
+
+// splitChain returns the delta chains of the halves of a data page split at
+// midKey. The deltas of chain are moved onto the halves, keeping their order.
+// Range tombstones that span the middle key are moved onto both.
+func splitChain(cmp Comparator, chain *delta, midKey []byte, left, right *page) (*delta, *delta) {
+	var leftHead, leftTail, rightHead, rightTail *delta
+	for d := chain; d.next != nil; d = d.next {
+		if cmp.Compare(d.key.key, midKey) < 0 {
+			leftHead, leftTail = appendDelta(leftHead, leftTail, d)
+		}
+		if cmp.Compare(midKey, d.key.key) <= 0 || d.key.end != nil && cmp.Compare(midKey, d.key.end) < 0 {
+			rightHead, rightTail = appendDelta(rightHead, rightTail, d)
+		}
+	}
+	leftPage := &delta{page: left}
+	if leftTail != nil {
+		leftTail.next = leftPage
+		leftPage = leftHead
+	}
+	rightPage := &delta{page: right}
+	if rightTail != nil {
+		rightTail.next = rightPage
+		rightPage = rightHead
+	}
+	return leftPage, rightPage
+}
@@ -30,3 +30,97 @@ func TestConsolidateOrder(t *testing.T) {
 		t.Fatalf("db.Get(%q) = %q; not %q", k, out, want)
 	}
 }
+
+// TestSplitMoveRight tests that readers and writers that reach a data page
+// after it has been half split, but before the split has been posted to its
+// parent, move right to the sibling.
+func TestSplitMoveRight(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 40
+
+	c := DefaultConfig
+	c.MaxKeysPerNode = 10
+	c.MaxDeltaCount = 1
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.consolidate(rootPage)
+	db.split(rootPage)
+	// Stop the worker from consolidating or splitting pages while reading.
+	db.ns.config.MaxDeltaCount = count
+
+	id := db.getPage(rootPage).next.page.left
+	p := db.getPage(id).next.getPage()
+	k := p.keyAt(len(p.keys) - 1)
+	if leaf, _, _, _ := db.findLeaf(db.ns, k); leaf != id {
+		t.Fatalf("db.findLeaf(%q) = %d; not %d", k, leaf, id)
+	}
+
+	right, midKey, ok := db.halfSplit(id)
+	if !ok {
+		t.Fatalf("db.halfSplit(%d) should split", id)
+	}
+	if db.getPage(rootPage).next.page.left != id {
+		t.Fatalf("the split should not be posted to the parent yet")
+	}
+	if leaf, _, high, _ := db.findLeaf(db.ns, k); leaf != right || !bytes.Equal(high, p.high) {
+		t.Fatalf("db.findLeaf(%q) = %d, %q; not %d, %q", k, leaf, high, right, p.high)
+	}
+	if leaf, _, high, _ := db.findLeaf(db.ns, p.keyAt(0)); leaf != id || !bytes.Equal(high, midKey) {
+		t.Fatalf("db.findLeaf(%q) = %d, %q; not %d, %q", p.keyAt(0), leaf, high, id, midKey)
+	}
+
+	// Reads and read intents go to the right sibling.
+	txn := db.NewTxn()
+	if v, _ := txn.Get(k); !bytes.Equal(v, k) {
+		t.Fatalf("txn.Get(%q) = %q; not %q", k, v, k)
+	}
+	if d := db.getPage(right).next; d.key == nil || !d.key.read || d.key.txn != txn {
+		t.Fatalf("the read intent should be on the right sibling")
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes go to the right sibling.
+	v := []byte("new")
+	if err := db.Put(k, v); err != nil {
+		t.Fatal(err)
+	}
+	if d := db.getPage(right).next; d.key == nil || !bytes.Equal(d.key.key, k) {
+		t.Fatalf("the write should be on the right sibling")
+	}
+	if out, _ := db.Get(k); !bytes.Equal(out, v) {
+		t.Fatalf("db.Get(%q) = %q; not %q", k, out, v)
+	}
+
+	if err := db.postSplit(db.ns, id, midKey, right); err != nil {
+		t.Fatal(err)
+	}
+	node := db.getPage(db.getPage(rootPage).next.page.left).next.page
+	if node.key == nil || !bytes.Equal(node.key, midKey) || node.left != id || node.right != right {
+		t.Fatalf("the split should be posted to the parent: %+v", node)
+	}
+	if out, _ := db.Get(k); !bytes.Equal(out, v) {
+		t.Fatalf("db.Get(%q) = %q; not %q", k, out, v)
+	}
+	if n := db.Count(nil, nil, zeroTime); n != count {
+		t.Fatalf("db.Count(nil, nil) = %d; not %d", n, count)
+	}
+	n := 0
+	for it := db.NewIterator(nil, nil); it.Prev(); {
+		n++
+	}
+	if n != count {
+		t.Fatalf("reverse iteration found %d keys; not %d", n, count)
+	}
+}
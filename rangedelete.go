@@ -0,0 +1,70 @@
+package skeleton
+
+import "github.com/pkg/errors"
+
+// ErrInvalidRange is returned when the start of a range isn't before the end.
+var ErrInvalidRange = errors.New("the start of the range must be before the end")
+
+// DeleteRange removes all keys in the range [start, end) from the database.
+func (db *DB) DeleteRange(start, end []byte) error {
+	return db.ns.DeleteRange(start, end)
+}
+
+// DeleteRange removes all keys in the range [start, end) from the namespace.
+func (ns *Namespace) DeleteRange(start, end []byte) error {
+	return ns.Txn(func(txn NamespaceTxn) error {
+		return txn.DeleteRange(start, end)
+	})
+}
+
+// DeleteRange removes all keys in the range [start, end) from the database.
+func (t *Txn) DeleteRange(start, end []byte) error {
+	return t.In(t.db.ns).DeleteRange(start, end)
+}
+
+// DeleteRange removes all keys in the range [start, end) from the namespace.
+func (t NamespaceTxn) DeleteRange(start, end []byte) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	if err := t.removeIndexedRange(start, end); err != nil {
+		return err
+	}
+	return t.db.deleteRange(t.ns, t.Txn, start, end)
+}
+
+// deleteRange writes a single range tombstone delta on to each data page that
+// overlaps with [start, end).
+func (db *DB) deleteRange(ns *Namespace, txn *Txn, start, end []byte) error {
+	if ns.comparator().Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+	if err := db.checkMemoryLimit(); err != nil {
+		return err
+	}
+	key := &key{
+		key: start,
+		end: end,
+		txn: txn,
+		values: []value{
+			{
+				tombstone: true,
+			},
+		},
+	}
+	if txn != nil {
+		key.seq = txn.nextSeq()
+	}
+	for k := start; ; {
+		// Each page gets its own copy, since it's timestamped when it's added.
+		page := key.clone()
+		high, err := db.insert(ns, &page, k, true)
+		if err != nil {
+			return err
+		}
+		if high == nil || ns.comparator().Compare(high, end) >= 0 {
+			return nil
+		}
+		k = high
+	}
+}
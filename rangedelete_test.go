@@ -0,0 +1,180 @@
+package skeleton
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+)
+
+// consolidateAll forces consolidation of every data page.
+func consolidateAll(db *DB) {
+	for i := range *db.pages {
+		id := pageID(i + 1)
+		if db.getPage(id).next != nil {
+			db.consolidate(id)
+		}
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	db := splitDB(t, count)
+	defer db.Close()
+
+	start, end := []byte("key2"), []byte("key5")
+	inRange := func(k []byte) bool {
+		return bytes.Compare(start, k) <= 0 && bytes.Compare(k, end) < 0
+	}
+	before := db.now()
+	if err := db.DeleteRange(start, end); err != nil {
+		t.Fatal(err)
+	}
+	recreated := []byte("key3")
+	if err := db.Put(recreated, recreated); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		for i := 0; i < count; i++ {
+			k := intToKey(i)
+			v, _ := db.Get(k)
+			if inRange(k) && !bytes.Equal(k, recreated) {
+				if v != nil {
+					t.Errorf("db.Get(%q) = %q; not nil", k, v)
+				}
+			} else if !bytes.Equal(v, k) {
+				t.Errorf("db.Get(%q) = %q; not %q", k, v, k)
+			}
+			if v, _ := db.GetAt(k, before); !bytes.Equal(v, k) {
+				t.Errorf("db.GetAt(%q, before) = %q; not %q", k, v, k)
+			}
+		}
+	}
+	check()
+
+	// Range tombstones are folded into per key tombstones.
+	consolidateAll(db)
+	check()
+
+	k := []byte("key42")
+	versions := db.History(k, zeroTime, zeroTime)
+	if len(versions) != 2 || !versions[0].Tombstone {
+		t.Fatalf("db.History(%q) = %+v; expected a tombstone and a value", k, versions)
+	}
+}
+
+func TestDeleteRangeTxn(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("b")
+	if err := db.Put(k, k); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := db.NewTxn()
+	if err := txn.DeleteRange([]byte("a"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := txn.Get(k); !ok || v != nil {
+		t.Fatalf("txn.Get(%q) = %q, %v; not nil, true", k, v, ok)
+	}
+	if v, _ := db.Get(k); !bytes.Equal(v, k) {
+		t.Fatalf("db.Get(%q) = %q; not %q", k, v, k)
+	}
+
+	// Pending range tombstones conflict with writes in the range.
+	txn2 := db.NewTxn()
+	if err := txn2.Put(k, k); err != ErrTxnConflict {
+		t.Fatalf("txn2.Put(%q) = %v; not %v", k, err, ErrTxnConflict)
+	}
+
+	if err := txn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get(k); !bytes.Equal(v, k) {
+		t.Fatalf("db.Get(%q) = %q; not %q", k, v, k)
+	}
+
+	if err := db.DeleteRange([]byte("c"), []byte("a")); err != ErrInvalidRange {
+		t.Fatalf("db.DeleteRange() = %v; not %v", err, ErrInvalidRange)
+	}
+}
+
+// TestDeleteRangeGC tests that versions deleted by old range tombstones are
+// garbage collected.
+func TestDeleteRangeGC(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.MaxDeltaCount = 1
+	c.GCTime = time.Nanosecond
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, b := []byte("a"), []byte("b")
+	for _, k := range [][]byte{a, b} {
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteRange(a, b); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	db.consolidate(rootPage)
+
+	if versions := db.History(a, zeroTime, zeroTime); len(versions) != 0 {
+		t.Fatalf("db.History(%q) = %+v; not empty", a, versions)
+	}
+	if v, _ := db.Get(b); !bytes.Equal(v, b) {
+		t.Fatalf("db.Get(%q) = %q; not %q", b, v, b)
+	}
+}
+
+// TestDeleteRangeReadError tests that a range delete that fails part way
+// through doesn't leave tombstones behind that block writes to the range.
+func TestDeleteRangeReadError(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	db := splitDBConfig(t, &c, count)
+	defer db.Close()
+
+	// Evict and corrupt the page holding the end of the range, so the range
+	// delete fails after writing tombstones on the pages before it.
+	start, end := intToKey(1), intToKey(9)
+	corruptPage(t, path, evictLeaf(t, db, intToKey(8)))
+
+	if err := db.DeleteRange(start, end); errors.Cause(err) != ErrChecksum {
+		t.Fatalf("db.DeleteRange = %+v; not ErrChecksum", err)
+	}
+	k := intToKey(10)
+	if err := db.Put(k, []byte("new")); err != nil {
+		t.Fatalf("db.Put(%q) after a failed range delete = %+v", k, err)
+	}
+	if v, _ := db.Get(k); !bytes.Equal(v, []byte("new")) {
+		t.Errorf("db.Get(%q) = %q; not new", k, v)
+	}
+	if v, _ := db.Get(intToKey(2)); !bytes.Equal(v, intToKey(2)) {
+		t.Errorf("db.Get(%q) = %q; should not be deleted", intToKey(2), v)
+	}
+}
@@ -0,0 +1,429 @@
+package skeleton
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// entry is a key and its value as seen by a reader, and the time of the
+// newest version of the value.
+type entry struct {
+	key, value []byte
+	time       time.Time
+}
+
+// byCmp implements sort.Interface for [][]byte using a comparator.
+type byCmp struct {
+	keys [][]byte
+	cmp  Comparator
+}
+
+func (a byCmp) Len() int           { return len(a.keys) }
+func (a byCmp) Swap(i, j int)      { a.keys[i], a.keys[j] = a.keys[j], a.keys[i] }
+func (a byCmp) Less(i, j int) bool { return a.cmp.Compare(a.keys[i], a.keys[j]) < 0 }
+
+// leafEntries returns the entries visible to txn at the specified time in the
+// delta chain d of a data page, that are within [start, end). A nil end leaves
+// the range unbounded. Deleted keys are skipped and the entries are sorted.
+func (db *DB) leafEntries(ns *Namespace, d *delta, txn *Txn, start, end []byte, at time.Time) []entry {
+	inRange := func(k []byte) bool {
+		return (start == nil || ns.comparator().Compare(start, k) <= 0) && (end == nil || ns.comparator().Compare(k, end) < 0)
+	}
+
+	// Collect every key that could have a value, then resolve them all at once.
+	var keys [][]byte
+	for c := d; c != nil; c = c.next {
+		if p := c.page; p != nil {
+			// The keys of the page are sorted, so only the ones in the range are
+			// read.
+			i := 0
+			if start != nil {
+				i = p.search(ns.comparator(), start)
+			}
+			j := len(p.keys)
+			if end != nil {
+				j = i + p.searchFrom(ns.comparator(), i, end)
+			}
+			keys = append(keys, p.keysAt(i, j)...)
+			break
+		}
+		if c.key.end == nil && !c.key.read && c.key.visibleTo(txn) && inRange(c.key.key) {
+			keys = append(keys, c.key.key)
+		}
+	}
+	sort.Sort(byCmp{keys, ns.comparator()})
+	unique := keys[:0]
+	for _, k := range keys {
+		if len(unique) == 0 || ns.comparator().Compare(unique[len(unique)-1], k) != 0 {
+			unique = append(unique, k)
+		}
+	}
+	keys = unique
+
+	lookups, _ := db.lookupChainMany(ns, d, txn, keys, at)
+	var entries []entry
+	for i, k := range keys {
+		if lookups[i].exists() {
+			v, _ := db.resolve(k, &lookups[i])
+			entries = append(entries, entry{key: k, value: v, time: lookups[i].time})
+		}
+	}
+	return entries
+}
+
+// Iterator iterates over the keys and values of a namespace in either
+// direction. It reads one data page at a time and moves between pages using
+// their sibling links, so it sees a consistent view of each page but not
+// necessarily of the whole range.
+type Iterator struct {
+	db         *DB
+	ns         *Namespace
+	txn        *Txn
+	at         time.Time
+	start, end []byte
+	prefix     []byte
+	// filter is whether keys without the prefix are skipped instead of ending
+	// the iteration.
+	filter bool
+
+	// id, d and leaf are the ID, delta chain and page of the current data page.
+	id      pageID
+	d       *delta
+	leaf    *page
+	entries []entry
+	i       int
+	started bool
+	valid   bool
+	// err is the error that stopped the iteration, if any.
+	err error
+	// buf is reused to rebuild the keys of pages with a prefix.
+	buf []byte
+}
+
+// NewIterator returns an iterator over the keys in [start, end) of the
+// database. A nil start or end leaves that side of the range unbounded.
+func (db *DB) NewIterator(start, end []byte) *Iterator {
+	return db.ns.NewIterator(start, end)
+}
+
+// NewIterator returns an iterator over the keys in [start, end) of the
+// namespace. A nil start or end leaves that side of the range unbounded.
+func (ns *Namespace) NewIterator(start, end []byte) *Iterator {
+	return ns.db.newIterator(ns, nil, start, end, zeroTime)
+}
+
+// NewIterator returns an iterator over the keys in [start, end) of the
+// database. Keys read by the iterator are registered with the transaction.
+func (t *Txn) NewIterator(start, end []byte) *Iterator {
+	return t.In(t.db.ns).NewIterator(start, end)
+}
+
+// NewIterator returns an iterator over the keys in [start, end) of the
+// namespace. Keys read by the iterator are registered with the transaction.
+func (t NamespaceTxn) NewIterator(start, end []byte) *Iterator {
+	if t.readOnly {
+		return t.db.newIterator(t.ns, nil, start, end, t.time)
+	}
+	return t.db.newIterator(t.ns, t.Txn, start, end, zeroTime)
+}
+
+// ScanPrefix returns an iterator over the keys in the database that start
+// with prefix. Unless the comparator is BytewiseComparator, keys with the same
+// prefix may not be ordered together, so every key is read to find them.
+func (db *DB) ScanPrefix(prefix []byte) *Iterator {
+	return db.ns.ScanPrefix(prefix)
+}
+
+// ScanPrefix returns an iterator over the keys in the namespace that start
+// with prefix. Unless the comparator is BytewiseComparator, keys with the same
+// prefix may not be ordered together, so every key is read to find them.
+func (ns *Namespace) ScanPrefix(prefix []byte) *Iterator {
+	return ns.NewIterator(nil, nil).limitToPrefix(prefix)
+}
+
+// ScanPrefix returns an iterator over the keys in the database that start
+// with prefix.
+func (t *Txn) ScanPrefix(prefix []byte) *Iterator {
+	return t.In(t.db.ns).ScanPrefix(prefix)
+}
+
+// ScanPrefix returns an iterator over the keys in the namespace that start
+// with prefix.
+func (t NamespaceTxn) ScanPrefix(prefix []byte) *Iterator {
+	return t.NewIterator(nil, nil).limitToPrefix(prefix)
+}
+
+// limitToPrefix limits the iterator to the keys that start with prefix. With
+// BytewiseComparator they're a single range, but otherwise the whole
+// namespace is filtered.
+func (it *Iterator) limitToPrefix(prefix []byte) *Iterator {
+	it.prefix = prefix
+	if it.ns.comparator() == BytewiseComparator {
+		it.start, it.end = prefix, prefixEnd(prefix)
+	} else {
+		it.filter = true
+	}
+	return it
+}
+
+// prefixEnd returns the smallest key that is bytewise greater than every key
+// starting with prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte{}, prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+func (db *DB) newIterator(ns *Namespace, txn *Txn, start, end []byte, at time.Time) *Iterator {
+	return &Iterator{
+		db:    db,
+		ns:    ns,
+		txn:   txn,
+		at:    at,
+		start: start,
+		end:   end,
+	}
+}
+
+// Next advances the iterator to the next key and returns whether there is
+// one. If the iterator hasn't been positioned yet, it moves to the first key.
+func (it *Iterator) Next() bool {
+	if !it.started {
+		return it.Seek(it.start)
+	}
+	if !it.valid {
+		return false
+	}
+	it.i++
+	if it.i < len(it.entries) {
+		return it.position()
+	}
+	return it.nextLeaf()
+}
+
+// Prev moves the iterator to the previous key and returns whether there is
+// one. If the iterator hasn't been positioned yet, it moves to the last key.
+func (it *Iterator) Prev() bool {
+	if !it.started {
+		return it.seekLast()
+	}
+	if !it.valid {
+		return false
+	}
+	it.i--
+	if it.i >= 0 {
+		return it.position()
+	}
+	return it.prevLeaf()
+}
+
+// Seek moves the iterator to the first key at or after k and returns whether
+// there is one.
+func (it *Iterator) Seek(k []byte) bool {
+	it.started = true
+	if it.start != nil && (k == nil || it.ns.comparator().Compare(k, it.start) < 0) {
+		k = it.start
+	}
+	if k == nil {
+		if !it.load(it.db.descend(it.ns.root, false)) {
+			return false
+		}
+	} else if id, d, _, err := it.db.findLeaf(it.ns, k); !it.load(id, d, err) {
+		return false
+	}
+	it.i = sort.Search(len(it.entries), func(i int) bool {
+		return k == nil || it.ns.comparator().Compare(it.entries[i].key, k) >= 0
+	})
+	if it.i < len(it.entries) {
+		return it.position()
+	}
+	return it.nextLeaf()
+}
+
+// SeekForPrev moves the iterator to the last key at or before k and returns
+// whether there is one.
+func (it *Iterator) SeekForPrev(k []byte) bool {
+	if it.end != nil && it.ns.comparator().Compare(k, it.end) >= 0 {
+		return it.seekLast()
+	}
+	it.started = true
+	if id, d, _, err := it.db.findLeaf(it.ns, k); !it.load(id, d, err) {
+		return false
+	}
+	it.i = sort.Search(len(it.entries), func(i int) bool {
+		return it.ns.comparator().Compare(it.entries[i].key, k) > 0
+	}) - 1
+	if it.i >= 0 {
+		return it.position()
+	}
+	return it.prevLeaf()
+}
+
+// seekLast moves the iterator to the last key in the range.
+func (it *Iterator) seekLast() bool {
+	it.started = true
+	if it.end == nil {
+		if !it.load(it.db.descend(it.ns.root, true)) {
+			return false
+		}
+	} else if id, d, _, err := it.db.findLeaf(it.ns, it.end); !it.load(id, d, err) {
+		return false
+	}
+	it.i = len(it.entries) - 1
+	if it.i >= 0 {
+		return it.position()
+	}
+	return it.prevLeaf()
+}
+
+// nextLeaf moves the iterator to the first key of the following data pages.
+func (it *Iterator) nextLeaf() bool {
+	for it.end == nil || !it.hasKey(func(k []byte) bool { return it.ns.comparator().Compare(k, it.end) >= 0 }) {
+		if it.leaf.next == 0 {
+			break
+		}
+		id := it.leaf.next
+		if d, err := it.db.getChain(id); !it.load(id, d, err) {
+			return false
+		}
+		if len(it.entries) > 0 {
+			it.i = 0
+			return it.position()
+		}
+	}
+	it.valid = false
+	return false
+}
+
+// prevLeaf moves the iterator to the last key of the preceding data pages.
+func (it *Iterator) prevLeaf() bool {
+	for it.start == nil || !it.hasKey(func(k []byte) bool { return it.ns.comparator().Compare(k, it.start) < 0 }) {
+		if it.leaf.prev == 0 {
+			break
+		}
+		// Pages split off of the left sibling are between it and this page.
+		id := it.leaf.prev
+		d, err := it.db.getChain(id)
+		for err == nil {
+			p := d.getPage()
+			if p.next == it.id || p.next == 0 {
+				break
+			}
+			id = p.next
+			d, err = it.db.getChain(id)
+		}
+		if !it.load(id, d, err) {
+			return false
+		}
+		if len(it.entries) > 0 {
+			it.i = len(it.entries) - 1
+			return it.position()
+		}
+	}
+	it.valid = false
+	return false
+}
+
+// hasKey returns whether the current data page has a key matching f, which
+// must match either every key after a key it matches or every key before. Since
+// the pages are ordered, if the page has a key outside of the range, the pages
+// past it don't need to be read.
+func (it *Iterator) hasKey(f func(k []byte) bool) bool {
+	for d := it.d; d != nil; d = d.next {
+		if d.key != nil && f(d.key.key) {
+			return true
+		}
+		// The keys of the page are sorted, so only the first and last can be
+		// the only ones matching.
+		if p := d.page; p != nil && len(p.keys) > 0 {
+			if it.buf = p.appendKey(it.buf[:0], 0); f(it.buf) {
+				return true
+			}
+			if it.buf = p.appendKey(it.buf[:0], len(p.keys)-1); f(it.buf) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// load reads the entries in the range of the data page, and returns whether it
+// could be read. If err is set, reading the page failed and the iteration
+// stops.
+func (it *Iterator) load(id pageID, d *delta, err error) bool {
+	if err != nil {
+		it.err = err
+		it.valid = false
+		return false
+	}
+	it.id = id
+	it.d = d
+	it.leaf = d.getPage()
+	it.entries = it.db.leafEntries(it.ns, d, it.txn, it.start, it.end, it.at)
+	if it.filter {
+		entries := it.entries[:0]
+		for _, e := range it.entries {
+			if bytes.HasPrefix(e.key, it.prefix) {
+				entries = append(entries, e)
+			}
+		}
+		it.entries = entries
+	}
+	return true
+}
+
+// position makes the entry at i the current position of the iterator.
+func (it *Iterator) position() bool {
+	e := it.entries[it.i]
+	if it.prefix != nil && !bytes.HasPrefix(e.key, it.prefix) {
+		it.valid = false
+		return false
+	}
+	if it.txn != nil {
+		if err := it.db.addReadIntent(it.ns, it.txn, e.key); err != nil {
+			it.err = err
+			it.valid = false
+			return false
+		}
+	}
+	it.valid = true
+	return true
+}
+
+// Valid returns whether the iterator is positioned at a key.
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+// Key returns the key at the current position of the iterator.
+func (it *Iterator) Key() []byte {
+	return it.entries[it.i].key
+}
+
+// Value returns the value at the current position of the iterator.
+func (it *Iterator) Value() []byte {
+	return it.entries[it.i].value
+}
+
+// Err returns the error that stopped the iteration, if a page couldn't be read
+// from the page store.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// scan calls f with each key and value visible to txn at the specified time
+// in [start, end) of the namespace, in order, until f returns false. A nil
+// start or end leaves that side of the range unbounded. If txn is set, read
+// intents are added for the keys that are read.
+func (db *DB) scan(ns *Namespace, txn *Txn, start, end []byte, at time.Time, f func(k, v []byte) bool) error {
+	it := db.newIterator(ns, txn, start, end, at)
+	for it.Next() && f(it.Key(), it.Value()) {
+	}
+	return it.Err()
+}
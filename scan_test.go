@@ -0,0 +1,334 @@
+package skeleton
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+// splitDB returns a database with count keys spread across several pages.
+func splitDB(t *testing.T, count int) *DB {
+	c := DefaultConfig
+	return splitDBConfig(t, &c, count)
+}
+
+// splitDBConfig is like splitDB, but opens the database with c after setting
+// its page size and delta count, so c can be used to reopen it.
+func splitDBConfig(t *testing.T, c *Config, count int) *DB {
+	c.MaxKeysPerNode = 10
+	c.MaxDeltaCount = 1
+	db, err := NewDB(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	splitAll(db)
+	return db
+}
+
+// splitAll consolidates and splits the pages of the database until the keys
+// are spread across several levels.
+func splitAll(db *DB) {
+	for i := 0; i < 5; i++ {
+		consolidateAll(db)
+		db.split(rootPage)
+	}
+}
+
+func iteratorKeys(t *testing.T, it *Iterator) []string {
+	t.Helper()
+
+	var keys []string
+	for it.Next() {
+		if !bytes.Equal(it.Key(), it.Value()) {
+			t.Errorf("it.Value() = %q; not %q", it.Value(), it.Key())
+		}
+		keys = append(keys, string(it.Key()))
+	}
+	return keys
+}
+
+func TestIterator(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	db := splitDB(t, count)
+	defer db.Close()
+
+	var want []string
+	for i := 0; i < count; i++ {
+		want = append(want, string(intToKey(i)))
+	}
+	sort.Strings(want)
+
+	if got := iteratorKeys(t, db.NewIterator(nil, nil)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("db.NewIterator(nil, nil) = %q; not %q", got, want)
+	}
+
+	start, end := []byte("key2"), []byte("key5")
+	var inRange []string
+	for _, k := range want {
+		if k >= string(start) && k < string(end) {
+			inRange = append(inRange, k)
+		}
+	}
+	if got := iteratorKeys(t, db.NewIterator(start, end)); !reflect.DeepEqual(got, inRange) {
+		t.Fatalf("db.NewIterator(%q, %q) = %q; not %q", start, end, got, inRange)
+	}
+
+	db.Delete([]byte("key3"))
+	db.Put([]byte("key30a"), []byte("key30a"))
+	got := iteratorKeys(t, db.ScanPrefix([]byte("key3")))
+	wantPrefix := []string{"key30", "key30a", "key31", "key32", "key33", "key34", "key35", "key36", "key37", "key38", "key39"}
+	if !reflect.DeepEqual(got, wantPrefix) {
+		t.Fatalf("db.ScanPrefix(key3) = %q; not %q", got, wantPrefix)
+	}
+}
+
+func TestIteratorTxn(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("a"))
+	db.Put([]byte("c"), []byte("c"))
+	snapshot, err := db.NewReadOnlyTxn(db.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := db.NewTxn()
+	txn.Put([]byte("b"), []byte("b"))
+	txn.Delete([]byte("c"))
+
+	if got, want := iteratorKeys(t, txn.NewIterator(nil, nil)), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("txn.NewIterator(nil, nil) = %q; not %q", got, want)
+	}
+	if got, want := iteratorKeys(t, db.NewIterator(nil, nil)), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("db.NewIterator(nil, nil) = %q; not %q", got, want)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := iteratorKeys(t, snapshot.NewIterator(nil, nil)), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot.NewIterator(nil, nil) = %q; not %q", got, want)
+	}
+}
+
+func TestIteratorReverse(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	db := splitDB(t, count)
+	defer db.Close()
+
+	var want []string
+	for i := 0; i < count; i++ {
+		want = append(want, string(intToKey(i)))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(want)))
+
+	var got []string
+	for it := db.NewIterator(nil, nil); it.Prev(); {
+		got = append(got, string(it.Key()))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("db.NewIterator(nil, nil).Prev() = %q; not %q", got, want)
+	}
+
+	// The latest entries under a prefix.
+	got = nil
+	for it := db.ScanPrefix([]byte("key4")); len(got) < 3 && it.Prev(); {
+		got = append(got, string(it.Key()))
+	}
+	if want := []string{"key49", "key48", "key47"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("db.ScanPrefix(key4).Prev() = %q; not %q", got, want)
+	}
+
+	it := db.NewIterator([]byte("key2"), []byte("key5"))
+	cases := []struct {
+		seek    func([]byte) bool
+		k, want string
+	}{
+		{it.Seek, "key25", "key25"},
+		{it.Seek, "key255", "key26"},
+		{it.Seek, "key1", "key2"},
+		{it.Seek, "key5", ""},
+		{it.SeekForPrev, "key25", "key25"},
+		{it.SeekForPrev, "key255", "key25"},
+		{it.SeekForPrev, "key9", "key49"},
+		{it.SeekForPrev, "key1", ""},
+	}
+	for i, c := range cases {
+		ok := c.seek([]byte(c.k))
+		if ok != (c.want != "") || ok != it.Valid() {
+			t.Errorf("%d. seek(%q) = %t; not %t", i, c.k, ok, c.want != "")
+			continue
+		}
+		if ok && string(it.Key()) != c.want {
+			t.Errorf("%d. seek(%q) = %q; not %q", i, c.k, it.Key(), c.want)
+		}
+	}
+
+	// Change direction after seeking.
+	it.Seek([]byte("key3"))
+	if !it.Prev() || string(it.Key()) != "key29" {
+		t.Fatalf("it.Prev() = %q; not key29", it.Key())
+	}
+	if !it.Next() || string(it.Key()) != "key3" {
+		t.Fatalf("it.Next() = %q; not key3", it.Key())
+	}
+}
+
+func TestSiblingLinks(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	db := splitDB(t, count)
+	defer db.Close()
+
+	// Walk the data pages left to right, then right to left.
+	var forward []pageID
+	id, d, _ := db.descend(rootPage, false)
+	for {
+		forward = append(forward, id)
+		next := d.getPage().next
+		if next == 0 {
+			break
+		}
+		id, d, _ = db.descend(next, false)
+	}
+	if len(forward) < 2 {
+		t.Fatalf("expected the database to be split, got %d data pages", len(forward))
+	}
+	var backward []pageID
+	id, d, _ = db.descend(rootPage, true)
+	for {
+		backward = append([]pageID{id}, backward...)
+		prev := d.getPage().prev
+		if prev == 0 {
+			break
+		}
+		id, d, _ = db.descend(prev, true)
+	}
+	if !reflect.DeepEqual(forward, backward) {
+		t.Fatalf("forward = %+v; backward = %+v", forward, backward)
+	}
+}
+
+// TestLeafEntriesRange checks that the range of a large consolidated page is
+// found by searching it.
+func TestLeafEntriesRange(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 500
+
+	c := DefaultConfig
+	c.MaxKeysPerNode = count * 2
+	c.MaxDeltaCount = 1
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var all []string
+	for i := 0; i < count; i++ {
+		k := intPrefix("tenant/1/", i)
+		all = append(all, string(k))
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(all)
+	db.consolidate(rootPage)
+	d, _ := db.getChain(rootPage)
+	if d.page == nil || len(d.page.keys) != count || d.page.prefix == nil {
+		t.Fatal("the keys should be consolidated into one page with a prefix")
+	}
+	// A delta in the range is merged with the page's keys.
+	if err := db.Put([]byte("tenant/1/250a"), []byte("delta")); err != nil {
+		t.Fatal(err)
+	}
+	all = append(all, "tenant/1/250a")
+	sort.Strings(all)
+	d, _ = db.getChain(rootPage)
+
+	for _, r := range [][2]string{
+		{"", ""},
+		{"tenant/1/100", "tenant/1/200"},
+		{"tenant/1/250", "tenant/1/251"},
+		{"tenant/1/4999", ""},
+		{"", "tenant/1/1"},
+		{"a", "b"},
+		{"tenant/1/", "tenant/2"},
+	} {
+		var start, end []byte
+		if r[0] != "" {
+			start = []byte(r[0])
+		}
+		if r[1] != "" {
+			end = []byte(r[1])
+		}
+		var want []string
+		for _, k := range all {
+			if (start == nil || k >= r[0]) && (end == nil || k < r[1]) {
+				want = append(want, k)
+			}
+		}
+		var got []string
+		for _, e := range db.leafEntries(db.ns, d, nil, start, end, zeroTime) {
+			got = append(got, string(e.key))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("db.leafEntries(%q, %q) = %d keys; not %d", r[0], r[1], len(got), len(want))
+		}
+	}
+}
+
+// TestScanPrefixComparator tests that prefix scans find every key with the
+// prefix when the comparator doesn't order them bytewise.
+func TestScanPrefixComparator(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.Comparator = reverseComparator{}
+	c.MaxKeysPerNode = 4
+	c.MaxDeltaCount = 1
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, k := range []string{"a", "b", "b1", "b2", "ba", "c", "c1", "d"} {
+		if err := db.Put([]byte(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	splitAll(db)
+
+	want := []string{"ba", "b2", "b1", "b"}
+	if got := iteratorKeys(t, db.ScanPrefix([]byte("b"))); !reflect.DeepEqual(got, want) {
+		t.Fatalf("db.ScanPrefix(b) = %q; not %q", got, want)
+	}
+	it := db.ScanPrefix([]byte("b"))
+	var got []string
+	for it.Prev() {
+		got = append(got, string(it.Key()))
+	}
+	if want := []string{"b", "b1", "b2", "ba"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("db.ScanPrefix(b) in reverse = %q; not %q", got, want)
+	}
+}
@@ -0,0 +1,181 @@
+package skeleton
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMemoryLimit is returned by writes when the memory used by the DB has
+// reached Config.MemoryLimit.
+var ErrMemoryLimit = errors.New("the memory limit has been reached")
+
+// Approximate sizes of the structures that make up pages.
+const (
+	deltaSize   = int64(unsafe.Sizeof(delta{}))
+	pageSize    = int64(unsafe.Sizeof(page{}))
+	keySize     = int64(unsafe.Sizeof(key{}))
+	valueSize   = int64(unsafe.Sizeof(value{}))
+	pointerSize = int64(unsafe.Sizeof(&key{}))
+)
+
+// Stats describes the approximate number of bytes used by the pages of a DB
+// that are in memory, and how well the pages written to the page store
+// compress.
+type Stats struct {
+	// Keys is the bytes used by keys.
+	Keys int64
+	// Values is the bytes used by the newest version of each key, including
+	// the versions written by deltas.
+	Values int64
+	// History is the bytes used by older versions that are kept for reads at
+	// earlier times.
+	History int64
+	// Deltas is the overhead of delta chains that haven't been consolidated.
+	Deltas int64
+	// Pages is the overhead of pages, including index nodes.
+	Pages int64
+	// Total is the sum of the above.
+	Total int64
+
+	// PageBytes is the size of the data pages written to the page store since
+	// the DB was opened, and CompressedPageBytes is their size once compressed.
+	PageBytes           int64
+	CompressedPageBytes int64
+	// CompressionRatio is PageBytes / CompressedPageBytes, or 0 if no data
+	// pages have been written.
+	CompressionRatio float64
+}
+
+// Stats returns the memory used by the DB.
+func (db *DB) Stats() Stats {
+	s := Stats{
+		Keys:    atomic.LoadInt64(&db.usage.keys),
+		Values:  atomic.LoadInt64(&db.usage.values),
+		History: atomic.LoadInt64(&db.usage.history),
+		Deltas:  atomic.LoadInt64(&db.usage.deltas),
+		Pages:   atomic.LoadInt64(&db.usage.pages),
+	}
+	s.Total = s.Keys + s.Values + s.History + s.Deltas + s.Pages
+	db.storeLock.RLock()
+	defer db.storeLock.RUnlock()
+	if db.store != nil {
+		s.PageBytes = atomic.LoadInt64(&db.store.pageBytes)
+		s.CompressedPageBytes = atomic.LoadInt64(&db.store.compressedPageBytes)
+		if s.CompressedPageBytes > 0 {
+			s.CompressionRatio = float64(s.PageBytes) / float64(s.CompressedPageBytes)
+		}
+	}
+	return s
+}
+
+// usage is the approximate number of bytes used by pages, by what they're used
+// for.
+type usage struct {
+	keys, values, history, deltas, pages int64
+}
+
+func (u usage) plus(o usage) usage {
+	return usage{
+		keys:    u.keys + o.keys,
+		values:  u.values + o.values,
+		history: u.history + o.history,
+		deltas:  u.deltas + o.deltas,
+		pages:   u.pages + o.pages,
+	}
+}
+
+func (u usage) minus(o usage) usage {
+	return u.plus(usage{
+		keys:    -o.keys,
+		values:  -o.values,
+		history: -o.history,
+		deltas:  -o.deltas,
+		pages:   -o.pages,
+	})
+}
+
+func (u usage) total() int64 {
+	return u.keys + u.values + u.history + u.deltas + u.pages
+}
+
+// usage returns the memory used by the key and its versions.
+func (k *key) usage() usage {
+	u := usage{keys: keySize + int64(len(k.key)+len(k.end))}
+	for i, v := range k.values {
+		if i == 0 {
+			u.values += valueSize + int64(len(v.value))
+		} else {
+			u.history += valueSize + int64(len(v.value))
+		}
+	}
+	return u
+}
+
+// usage returns the memory used by the page and its keys.
+func (p *page) usage() usage {
+	u := usage{
+		keys:  int64(len(p.prefix)),
+		pages: pageSize + int64(len(p.key)+len(p.high)) + pointerSize*int64(len(p.keys)),
+	}
+	for _, k := range p.keys {
+		u = u.plus(k.usage())
+	}
+	return u
+}
+
+// usage returns the memory used by the delta, but not the rest of its chain.
+// Stubs of pages that are in the page store aren't counted.
+func (d *delta) usage() usage {
+	if d.page != nil {
+		if d.page.stub {
+			return usage{}
+		}
+		return d.page.usage().plus(usage{deltas: deltaSize})
+	}
+	var u usage
+	if d.key != nil {
+		u = d.key.usage()
+	}
+	return u.plus(usage{deltas: deltaSize})
+}
+
+// chainUsage returns the memory used by a delta chain.
+func chainUsage(d *delta) usage {
+	var u usage
+	for ; d != nil; d = d.next {
+		u = u.plus(d.usage())
+	}
+	return u
+}
+
+// memoryUsed returns the total memory used by pages.
+func (db *DB) memoryUsed() int64 {
+	return db.Stats().Total
+}
+
+// addUsage adds to the memory used by pages, and schedules eviction if that
+// exceeds the memory budget.
+func (db *DB) addUsage(u usage) {
+	atomic.AddInt64(&db.usage.keys, u.keys)
+	atomic.AddInt64(&db.usage.values, u.values)
+	atomic.AddInt64(&db.usage.history, u.history)
+	atomic.AddInt64(&db.usage.deltas, u.deltas)
+	atomic.AddInt64(&db.usage.pages, u.pages)
+	if db.config.MemoryBudget > 0 && db.memoryUsed() > db.config.MemoryBudget {
+		select {
+		case db.evictQueue <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// checkMemoryLimit returns ErrMemoryLimit if the memory limit has been
+// reached.
+func (db *DB) checkMemoryLimit() error {
+	if db.config.MemoryLimit > 0 && db.memoryUsed() >= db.config.MemoryLimit {
+		return ErrMemoryLimit
+	}
+	return nil
+}
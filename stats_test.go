@@ -0,0 +1,145 @@
+package skeleton
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+)
+
+// pagesUsage returns the memory used by all pages in the mapping table.
+func pagesUsage(db *DB) usage {
+	var u usage
+	for _, slot := range *db.pages {
+		u = u.plus(chainUsage(slot.next))
+	}
+	return u
+}
+
+// pagesSize returns the total memory used by all pages in the mapping table.
+func pagesSize(db *DB) int64 {
+	return pagesUsage(db).total()
+}
+
+func TestMemoryAccounting(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 200
+
+	db := splitDB(t, count)
+
+	ns, err := db.Namespace("ns", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Put([]byte("a"), []byte("b"))
+	db.Delete(intToKey(1))
+	db.DeleteRange(intToKey(2), intToKey(3))
+	txn := db.NewTxn()
+	txn.Get(intToKey(4))
+	txn.Put(intToKey(5), []byte("txn"))
+	consolidateAll(db)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	consolidateAll(db)
+
+	// Stop the worker so it doesn't split pages while they're measured.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.memoryUsed(), pagesSize(db); got != want {
+		t.Fatalf("db.memoryUsed() = %d; not %d", got, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.MaxDeltaCount = 100
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	empty := db.Stats()
+	if empty.Keys != 0 || empty.Values != 0 || empty.History != 0 || empty.Deltas == 0 || empty.Pages == 0 {
+		t.Fatalf("db.Stats() = %+v; should only have overhead", empty)
+	}
+
+	k, v := []byte("key"), []byte("value")
+	db.Put(k, v)
+	s := db.Stats()
+	if s.Keys != keySize+int64(len(k)) || s.Values != valueSize+int64(len(v)) || s.History != 0 {
+		t.Fatalf("db.Stats() = %+v", s)
+	}
+	if s.Deltas != empty.Deltas+deltaSize {
+		t.Fatalf("db.Stats().Deltas = %d; not %d", s.Deltas, empty.Deltas+deltaSize)
+	}
+
+	// Overwriting moves the old version to the history once consolidated.
+	db.Put(k, v)
+	db.ns.config.MaxDeltaCount = 1
+	db.consolidate(rootPage)
+	s = db.Stats()
+	if s.Keys != keySize+int64(len(k)) || s.Values != valueSize+int64(len(v)) || s.History != valueSize+int64(len(v)) {
+		t.Fatalf("db.Stats() = %+v", s)
+	}
+	if s.Deltas != empty.Deltas {
+		t.Fatalf("db.Stats().Deltas = %d; not %d", s.Deltas, empty.Deltas)
+	}
+	if s.Total != s.Keys+s.Values+s.History+s.Deltas+s.Pages {
+		t.Fatalf("db.Stats().Total = %d; not the sum of %+v", s.Total, s)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.MemoryLimit = 4096
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var i int
+	for ; i < 1000; i++ {
+		if err := db.Put(intToKey(i), intToKey(i)); err == ErrMemoryLimit {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if i == 1000 {
+		t.Fatalf("db.Put should have returned ErrMemoryLimit")
+	}
+	if total := db.Stats().Total; total < c.MemoryLimit || total > c.MemoryLimit+1024 {
+		t.Fatalf("db.Stats().Total = %d; should be just over %d", total, c.MemoryLimit)
+	}
+	if _, err := db.Increment(intToKey(0), 1); err != ErrMemoryLimit {
+		t.Fatalf("db.Increment = %+v; not ErrMemoryLimit", err)
+	}
+	if err := db.Delete(intToKey(0)); err != ErrMemoryLimit {
+		t.Fatalf("db.Delete = %+v; not ErrMemoryLimit", err)
+	}
+	if err := db.DeleteRange(intToKey(0), intToKey(1)); err != ErrMemoryLimit {
+		t.Fatalf("db.DeleteRange = %+v; not ErrMemoryLimit", err)
+	}
+	var buf bytes.Buffer
+	if err := db.Backup(&buf, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	c.MemoryLimit = 1
+	restored, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if err := restored.Restore(&buf); err != ErrMemoryLimit {
+		t.Fatalf("restored.Restore = %+v; not ErrMemoryLimit", err)
+	}
+}
@@ -0,0 +1,661 @@
+package skeleton
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// storeMagic identifies a page store file. Its last byte is the version of the
+// format.
+var storeMagic = []byte("skdbpgs1")
+
+// The header of a page store is the magic followed by two checkpoint slots.
+// Each slot holds the offset of a checkpoint and a CRC-32C of it. A new
+// checkpoint is pointed at by overwriting the slot of the older one once it has
+// been synced, so a slot torn by a crash still leaves the other one intact.
+const (
+	checkpointSlotSize = 12
+	storeHeaderSize    = 8 + 2*checkpointSlotSize
+)
+
+// ErrChecksum is returned when a persisted record doesn't match its checksum.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// castagnoli is the table for the CRC-32C checksums of records.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Record types.
+const (
+	pageRecordType byte = iota + 1
+	checkpointRecordType
+)
+
+// recordHeaderSize is the size of the length, type and checksum before each
+// record.
+const recordHeaderSize = 9
+
+// checksum returns the CRC-32C of the length and type at the start of the
+// header of a record, and of its payload.
+func checksum(header, payload []byte) uint32 {
+	return crc32.Update(crc32.Update(0, castagnoli, header[:5]), castagnoli, payload)
+}
+
+// pageStore is an append-only file of page and checkpoint records, like the
+// log-structured store of LLAMA. Records are never overwritten: a new version
+// of a page is appended and the mapping table is pointed at it, so all writes
+// are sequential. The latest checkpoint holds the offsets of the current
+// version of every page. The payload of each record is encrypted if there is a
+// KeyProvider.
+type pageStore struct {
+	mu sync.Mutex
+	f  *os.File
+	// path is where the file is, which differs from f.Name() once a compacted
+	// file has been moved into place.
+	path string
+	size int64
+	// checkpoint is the offset of the latest checkpoint, or 0 if there is none.
+	checkpoint int64
+	// slots are the offsets the checkpoint slots in the header point at.
+	slots [2]int64
+	// compacted is the size of the file when it was opened or last compacted.
+	compacted int64
+	// compression is the algorithm data pages are written with.
+	compression Compression
+	// pageBytes and compressedPageBytes are the sizes of the data pages written
+	// before and after compression.
+	pageBytes, compressedPageBytes int64
+	// keys provides the keys records are encrypted with, or is nil if they
+	// aren't encrypted.
+	keys    KeyProvider
+	ciphers ciphers
+	// sealedKeyID is the ID of the key the last record was encrypted with.
+	sealedKeyID uint32
+	// rotated is set once the file may hold records encrypted with a key other
+	// than the current one.
+	rotated bool
+}
+
+// openPageStore opens or creates the page store at path. Records written
+// after the latest checkpoint may be torn by a crash, so the first of them that
+// can't be read is truncated along with everything after it. Any other damage
+// fails with ErrCorrupt and leaves the file as it is.
+func openPageStore(path string) (*pageStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &pageStore{f: f, path: path}
+	if err := s.scan(); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "opening page store %s", path)
+	}
+	return s, nil
+}
+
+// scan validates the header of the file and the records up to the latest
+// checkpoint, and truncates the unreadable tail after it.
+func (s *pageStore) scan() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		header := make([]byte, storeHeaderSize)
+		copy(header, storeMagic)
+		for i := range s.slots {
+			putCheckpointSlot(header[checkpointSlotOffset(i):], 0)
+		}
+		if _, err := s.f.WriteAt(header, 0); err != nil {
+			return err
+		}
+		s.size = storeHeaderSize
+		s.compacted = s.size
+		return nil
+	}
+
+	header := make([]byte, storeHeaderSize)
+	n := len(storeMagic) - 1
+	if _, err := s.f.ReadAt(header, 0); err != nil || string(header[:n]) != string(storeMagic[:n]) {
+		return errors.New("not a page store")
+	}
+	if header[n] != storeMagic[n] {
+		return errors.Errorf("unknown page store version %q", header[n])
+	}
+	// The newest checkpoint is the one the slots point furthest into the file
+	// at. A slot that fails its checksum was torn while a checkpoint was being
+	// written, and the checkpoint it was going to point at is ignored.
+	for i := range s.slots {
+		off, ok := checkpointSlot(header[checkpointSlotOffset(i):])
+		if !ok {
+			log.Printf("page store: ignoring torn checkpoint slot %d", i)
+			continue
+		}
+		if off != 0 && (off < storeHeaderSize || off >= info.Size()) {
+			return errors.Wrapf(ErrCorrupt, "checkpoint slot %d points at %d", i, off)
+		}
+		s.slots[i] = off
+		if off > s.checkpoint {
+			s.checkpoint = off
+		}
+	}
+
+	// Every record up to the checkpoint was synced before it was written, so
+	// they must line up with it exactly. Pages are verified when they're read.
+	off := int64(storeHeaderSize)
+	for off < s.checkpoint {
+		end, err := s.recordEnd(off, s.checkpoint)
+		if err != nil {
+			return err
+		}
+		off = end
+	}
+	if off != s.checkpoint {
+		return errors.Wrapf(ErrCorrupt, "record at %d overlaps the checkpoint at %d", off, s.checkpoint)
+	}
+	if s.checkpoint != 0 {
+		typ, payload, err := s.read(s.checkpoint)
+		if err != nil {
+			return errors.Wrapf(ErrCorrupt, "checkpoint at %d: %v", s.checkpoint, err)
+		}
+		if typ != checkpointRecordType {
+			return errors.Wrapf(ErrCorrupt, "record at %d isn't a checkpoint", s.checkpoint)
+		}
+		off += recordHeaderSize + int64(len(payload))
+	}
+
+	// Records after the checkpoint weren't synced, so the first one that
+	// can't be read is where a crash tore the file.
+	for off < info.Size() {
+		end, err := s.recordEnd(off, info.Size())
+		if err != nil {
+			break
+		}
+		if _, _, err := s.read(off); err != nil {
+			break
+		}
+		off = end
+	}
+	if off < info.Size() {
+		log.Printf("page store: truncating partial record at %d", off)
+		if err := s.f.Truncate(off); err != nil {
+			return err
+		}
+	}
+	s.size = off
+	s.compacted = off
+	return nil
+}
+
+// recordEnd returns the end of the record at the offset from its header, which
+// must be before limit.
+func (s *pageStore) recordEnd(off, limit int64) (int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := s.f.ReadAt(header, off); err != nil {
+		return 0, errors.Wrapf(ErrCorrupt, "reading record at %d: %v", off, err)
+	}
+	end := off + recordHeaderSize + int64(binary.BigEndian.Uint32(header))
+	if end > limit {
+		return 0, errors.Wrapf(ErrCorrupt, "record at %d ends at %d, after %d", off, end, limit)
+	}
+	return end, nil
+}
+
+// checkpointSlotOffset returns where the checkpoint slot i is in the header.
+func checkpointSlotOffset(i int) int {
+	return len(storeMagic) + i*checkpointSlotSize
+}
+
+// putCheckpointSlot encodes a checkpoint slot pointing at the offset into b.
+func putCheckpointSlot(b []byte, off int64) {
+	binary.BigEndian.PutUint64(b, uint64(off))
+	binary.BigEndian.PutUint32(b[8:], crc32.Checksum(b[:8], castagnoli))
+}
+
+// checkpointSlot decodes a checkpoint slot, and returns whether it matches its
+// checksum.
+func checkpointSlot(b []byte) (int64, bool) {
+	if crc32.Checksum(b[:8], castagnoli) != binary.BigEndian.Uint32(b[8:]) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(b)), true
+}
+
+// needsCompaction returns whether the file has doubled in size since it was
+// last compacted, or may hold records encrypted with an old key.
+func (s *pageStore) needsCompaction() bool {
+	return s.size > 2*s.compacted || s.rotated
+}
+
+// append appends a record and returns its offset.
+func (s *pageStore) append(typ byte, payload []byte) (int64, error) {
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	buf[4] = typ
+	binary.BigEndian.PutUint32(buf[5:], checksum(buf, payload))
+	copy(buf[recordHeaderSize:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off := s.size
+	if _, err := s.f.WriteAt(buf, off); err != nil {
+		return 0, err
+	}
+	s.size += int64(len(buf))
+	return off, nil
+}
+
+// read returns the type and payload of the record at the offset, after
+// verifying its checksum. The payload is still encrypted.
+func (s *pageStore) read(off int64) (byte, []byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := s.f.ReadAt(header, off); err != nil {
+		return 0, nil, errors.Wrapf(err, "reading record at %d", off)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := s.f.ReadAt(payload, off+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			err = ErrCorrupt
+		}
+		return 0, nil, errors.Wrapf(err, "reading record at %d", off)
+	}
+	if checksum(header, payload) != binary.BigEndian.Uint32(header[5:]) {
+		return 0, nil, errors.Wrapf(ErrChecksum, "record at %d", off)
+	}
+	return header[4], payload, nil
+}
+
+// readPage reads the page at the offset into the namespace.
+func (s *pageStore) readPage(off int64, ns *Namespace) (*page, error) {
+	typ, sealed, err := s.read(off)
+	if err != nil {
+		return nil, err
+	}
+	if typ != pageRecordType {
+		return nil, errors.Wrapf(ErrCorrupt, "record at %d isn't a page", off)
+	}
+	payload, keyID, err := s.open(typ, sealed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypting page at %d", off)
+	}
+	b, err := decompress(payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decompressing page at %d", off)
+	}
+	p, err := decodePage(b, ns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding page at %d", off)
+	}
+	p.offset = off
+	p.keyID = keyID
+	return p, nil
+}
+
+// writePage appends the page and returns its offset and the ID of the key it
+// was encrypted with. Data pages are compressed, but index pages are small and
+// left as is.
+func (s *pageStore) writePage(p *page) (int64, uint32, error) {
+	b := encodePage(p)
+	payload := compress(NoCompression, b)
+	if p.key == nil {
+		payload = compress(s.compression, b)
+		atomic.AddInt64(&s.pageBytes, int64(len(b)))
+		atomic.AddInt64(&s.compressedPageBytes, int64(len(payload)))
+	}
+	sealed, keyID, err := s.seal(pageRecordType, payload)
+	if err != nil {
+		return 0, 0, err
+	}
+	off, err := s.append(pageRecordType, sealed)
+	return off, keyID, err
+}
+
+// writeCheckpoint appends the checkpoint and syncs the file, and then points
+// the older checkpoint slot at it and syncs the file again.
+func (s *pageStore) writeCheckpoint(c *checkpoint) error {
+	sealed, _, err := s.seal(checkpointRecordType, c.encode())
+	if err != nil {
+		return err
+	}
+	off, err := s.append(checkpointRecordType, sealed)
+	if err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	i := 0
+	if s.slots[1] < s.slots[0] {
+		i = 1
+	}
+	slot := make([]byte, checkpointSlotSize)
+	putCheckpointSlot(slot, off)
+	if _, err := s.f.WriteAt(slot, int64(checkpointSlotOffset(i))); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.slots[i] = off
+	s.checkpoint = off
+	return nil
+}
+
+// readCheckpoint returns the latest checkpoint, or nil if there is none.
+func (s *pageStore) readCheckpoint() (*checkpoint, error) {
+	if s.checkpoint == 0 {
+		return nil, nil
+	}
+	typ, sealed, err := s.read(s.checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	payload, _, err := s.open(typ, sealed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypting checkpoint at %d", s.checkpoint)
+	}
+	c, err := decodeCheckpoint(payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding checkpoint at %d", s.checkpoint)
+	}
+	return c, nil
+}
+
+func (s *pageStore) close() error {
+	return s.f.Close()
+}
+
+// flush writes a page to the page store, if there is one, and records where it
+// was written. Failures are logged, and the page is written again by the next
+// checkpoint.
+func (db *DB) flush(p *page) {
+	if db.store == nil {
+		return
+	}
+	off, keyID, err := db.store.writePage(p)
+	if err != nil {
+		log.Printf("flush %+v: %+v", p.id, err)
+		return
+	}
+	p.offset = off
+	p.keyID = keyID
+}
+
+// checkpoint writes every page that isn't in the page store yet, followed by
+// a checkpoint record pointing at the current version of every page. Pages with
+// deltas are consolidated first, dropping the deltas of transactions that
+// hadn't committed when it started, so commits don't wait for it and each
+// transaction is either entirely in it or not at all. Pages encrypted with an
+// old key are rewritten with the current one. It must only be called from the
+// worker loop, or once the workers have stopped. Pages and namespaces created
+// while it runs are left for the next checkpoint.
+func (db *DB) checkpoint() error {
+	keyID, err := db.store.currentKeyID()
+	if err != nil {
+		return err
+	}
+	at := db.now()
+	db.snapshot(at)
+	c := &checkpoint{
+		largestPageID: atomic.LoadInt64(&db.largestPageID),
+		lastTime:      at.UnixNano(),
+	}
+	nsIndex := map[*Namespace]int{}
+	addNamespace := func(ns *Namespace) {
+		if int64(ns.root) > c.largestPageID {
+			return
+		}
+		nsIndex[ns] = len(c.namespaces)
+		cmpName := ns.cmpName
+		if cmp := ns.comparator(); cmp != nil {
+			cmpName = cmp.Name()
+		}
+		c.namespaces = append(c.namespaces, namespaceRecord{
+			name:           ns.name,
+			root:           ns.root,
+			comparator:     cmpName,
+			maxKeysPerNode: ns.config.MaxKeysPerNode,
+			maxDeltaCount:  ns.config.MaxDeltaCount,
+			gcTime:         ns.config.GCTime,
+		})
+	}
+	addNamespace(db.ns)
+	if namespaces := (*map[string]*Namespace)(atomic.LoadPointer(&db.namespaces)); namespaces != nil {
+		for _, ns := range *namespaces {
+			addNamespace(ns)
+		}
+	}
+
+	for i, slot := range *db.pages {
+		if int64(i) >= c.largestPageID {
+			break
+		}
+		d := slot.next
+		if d == nil {
+			continue
+		}
+		ns, ok := nsIndex[d.getPage().ns]
+		if !ok {
+			continue
+		}
+		p := d.page
+		if d.key != nil {
+			p, _, _ = db.consolidateChain(d, at)
+		}
+		if p.stub && p.keyID != keyID {
+			if p, err = db.store.readPage(p.offset, p.ns); err != nil {
+				return err
+			}
+		}
+		if p.offset == 0 || p.keyID != keyID {
+			if p.offset, p.keyID, err = db.store.writePage(p); err != nil {
+				return err
+			}
+		}
+		c.pages = append(c.pages, pageRecord{
+			id:     pageID(i + 1),
+			offset: p.offset,
+			ns:     ns,
+			keyID:  p.keyID,
+		})
+	}
+	return db.store.writeCheckpoint(c)
+}
+
+// backgroundCheckpoint writes a checkpoint while the DB is in use, and then
+// compacts the page store if it needs it.
+func (db *DB) backgroundCheckpoint() {
+	if err := db.checkpoint(); err != nil {
+		log.Printf("checkpoint: %+v", err)
+		return
+	}
+	if db.store.needsCompaction() {
+		if err := db.compact(); err != nil {
+			log.Printf("compact: %+v", err)
+		}
+	}
+}
+
+// compact copies the records of the latest checkpoint to a new file that then
+// replaces the page store, dropping the old versions of pages. Records are
+// copied as is, so pages keep the key they're encrypted with. Pages in memory
+// whose version isn't in the checkpoint are written again by the next one. It
+// must only be called from the worker loop, or once the workers have stopped.
+func (db *DB) compact() error {
+	old := db.store
+	c, err := old.readCheckpoint()
+	if err != nil || c == nil {
+		return err
+	}
+	path := old.path
+	tmp := path + ".compact"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	store, err := openPageStore(tmp)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		store.close()
+		os.Remove(tmp)
+		return err
+	}
+	store.compression = old.compression
+	store.keys = old.keys
+	store.pageBytes = atomic.LoadInt64(&old.pageBytes)
+	store.compressedPageBytes = atomic.LoadInt64(&old.compressedPageBytes)
+
+	// copyRecord copies the record at the offset in the old page store.
+	copyRecord := func(off int64) (int64, error) {
+		typ, payload, err := old.read(off)
+		if err != nil {
+			return 0, err
+		}
+		return store.append(typ, payload)
+	}
+	oldOffsets := map[pageID]int64{}
+	records := map[pageID]pageRecord{}
+	for i := range c.pages {
+		r := &c.pages[i]
+		oldOffsets[r.id] = r.offset
+		if r.offset, err = copyRecord(r.offset); err != nil {
+			return fail(err)
+		}
+		records[r.id] = *r
+	}
+	// Stubs that aren't in the checkpoint, because their namespace was created
+	// while it was written, still have to point at a copy of their page. Only
+	// the worker loop creates stubs, so there are no new ones after this.
+	for i, slot := range *db.pages {
+		id := pageID(i + 1)
+		d := slot.next
+		if _, ok := records[id]; ok || d == nil || d.page == nil || !d.page.stub {
+			continue
+		}
+		off, err := copyRecord(d.page.offset)
+		if err != nil {
+			return fail(err)
+		}
+		oldOffsets[id] = d.page.offset
+		records[id] = pageRecord{id: id, offset: off, keyID: d.page.keyID}
+	}
+	if err := store.writeCheckpoint(c); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fail(err)
+	}
+
+	db.storeLock.Lock()
+	defer db.storeLock.Unlock()
+	for i, slot := range *db.pages {
+		d := slot.next
+		if d == nil {
+			continue
+		}
+		p := d.getPage()
+		if p == nil || p.offset == 0 {
+			continue
+		}
+		id := pageID(i + 1)
+		r := records[id]
+		switch {
+		case p.stub:
+			// A stub's page is unchanged since the checkpoint, which may have
+			// rewritten it with the current key.
+			p.offset, p.keyID = r.offset, r.keyID
+		case p.offset == oldOffsets[id]:
+			p.offset = r.offset
+		default:
+			p.offset = 0
+		}
+	}
+	db.store = store
+	store.path = path
+	store.compacted = store.size
+	return old.close()
+}
+
+// load replaces the mapping table with stubs of the pages in the latest
+// checkpoint of the page store, if there is one. Pages are read from the store
+// when they're first used.
+func (db *DB) load() error {
+	c, err := db.store.readCheckpoint()
+	if err != nil || c == nil {
+		return err
+	}
+	keyID, err := db.store.currentKeyID()
+	if err != nil {
+		return err
+	}
+	for _, r := range c.pages {
+		if r.keyID != keyID {
+			db.store.rotated = true
+		}
+	}
+
+	namespaces := map[string]*Namespace{}
+	byIndex := make([]*Namespace, len(c.namespaces))
+	for i, r := range c.namespaces {
+		if r.name == "" {
+			if r.comparator != db.ns.comparator().Name() {
+				return ErrComparatorMismatch
+			}
+			db.ns.root = r.root
+			byIndex[i] = db.ns
+			continue
+		}
+		ns := &Namespace{
+			db:      db,
+			name:    r.name,
+			root:    r.root,
+			config:  db.config,
+			cmpName: r.comparator,
+		}
+		ns.config.MaxKeysPerNode = r.maxKeysPerNode
+		ns.config.MaxDeltaCount = r.maxDeltaCount
+		ns.config.GCTime = r.gcTime
+		// The comparators the DB already knows are resolved now, and any other
+		// one when the namespace is opened with it.
+		for _, cmp := range []Comparator{BytewiseComparator, db.config.comparator()} {
+			if r.comparator == cmp.Name() {
+				ns.setComparator(cmp)
+				ns.config.Comparator = cmp
+				break
+			}
+		}
+		namespaces[r.name] = ns
+		byIndex[i] = ns
+	}
+
+	pages := make([]*delta, c.largestPageID)
+	for i := range pages {
+		pages[i] = &delta{}
+	}
+	for _, r := range c.pages {
+		pages[r.id-1].next = &delta{
+			page: &page{
+				id:     r.id,
+				ns:     byIndex[r.ns],
+				offset: r.offset,
+				keyID:  r.keyID,
+				stub:   true,
+			},
+		}
+	}
+	db.pages = &pages
+	db.usage = usage{}
+	db.largestPageID = c.largestPageID
+	atomic.StorePointer(&db.namespaces, unsafe.Pointer(&namespaces))
+	db.clock.update(time.Unix(0, c.lastTime))
+	return nil
+}
@@ -0,0 +1,489 @@
+package skeleton
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+)
+
+// tempPath returns a path in a new temporary directory, and a function that
+// removes the directory.
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "skeletondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "pages"), func() { os.RemoveAll(dir) }
+}
+
+func TestPageEncoding(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	pages := []*page{
+		{id: 3, key: []byte("separator"), left: 4, right: 5},
+		{id: 4, high: []byte("separator"), prev: 2, next: 5},
+		{
+			id:   5,
+			prev: 4,
+			keys: []*key{
+				{key: []byte("a"), values: []value{{value: []byte("1"), time: now}}},
+				{key: []byte("b"), values: []value{
+					{time: now.Add(time.Second), tombstone: true},
+					{value: []byte{}, time: now},
+				}},
+				{key: []byte("c"), values: []value{{time: now}}},
+			},
+		},
+		{
+			id:     6,
+			prefix: []byte("tenant/"),
+			keys: []*key{
+				{key: []byte("1"), values: []value{{value: []byte("1"), time: now}}},
+				{key: []byte("2"), values: []value{{value: []byte("2"), time: now}}},
+			},
+		},
+	}
+	for _, p := range pages {
+		updateCountsIfData(p)
+		out, err := decodePage(encodePage(p), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, p) {
+			t.Errorf("decodePage(encodePage(%+v)) = %+v", p, out)
+		}
+	}
+
+	if _, err := decodePage(encodePage(pages[2])[:10], nil); err != ErrCorrupt {
+		t.Errorf("decodePage(truncated) = %+v; not ErrCorrupt", err)
+	}
+}
+
+// updateCountsIfData updates the key counts of data pages so they compare
+// equal to decoded pages.
+func updateCountsIfData(p *page) {
+	if p.key == nil {
+		p.updateCounts()
+	}
+}
+
+func TestPersist(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 200
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.MaxKeysPerNode = 10
+	c.MaxDeltaCount = 1
+	c.Path = path
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.consolidate(rootPage)
+	if d, _ := db.getChain(rootPage); d.getPage().offset == 0 {
+		t.Errorf("consolidated page %d should have been flushed", rootPage)
+	}
+	splitAll(db)
+	before := db.now()
+	deleted := intToKey(42)
+	if err := db.Delete(deleted); err != nil {
+		t.Fatal(err)
+	}
+	ns, err := db.Namespace("ns", &Config{MaxKeysPerNode: 5, MaxDeltaCount: 2, GCTime: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Put([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	// Uncommitted writes aren't persisted.
+	txn := db.NewTxn()
+	txn.Put([]byte("pending"), []byte("pending"))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, slot := range *db.pages {
+		if slot.next != nil && !slot.next.page.stub {
+			t.Fatalf("page %d should be read lazily", i+1)
+		}
+	}
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		v, _ := db.Get(k)
+		if bytes.Equal(k, deleted) {
+			if v != nil {
+				t.Errorf("db.Get(%q) = %q; not nil", k, v)
+			}
+			if v, _ := db.GetAt(k, before); !bytes.Equal(v, k) {
+				t.Errorf("db.GetAt(%q, before) = %q; not %q", k, v, k)
+			}
+		} else if !bytes.Equal(v, k) {
+			t.Errorf("db.Get(%q) = %q; not %q", k, v, k)
+		}
+	}
+	if n := db.Count(nil, nil, zeroTime); n != count-1 {
+		t.Errorf("db.Count(nil, nil) = %d; not %d", n, count-1)
+	}
+	if v, ok := db.Get([]byte("pending")); ok {
+		t.Errorf("db.Get(pending) = %q; should not be ok", v)
+	}
+	if !db.now().After(before) {
+		t.Errorf("timestamps should increase across restarts")
+	}
+
+	ns, err = db.Namespace("ns", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.config.MaxKeysPerNode != 5 || ns.config.MaxDeltaCount != 2 || ns.config.GCTime != time.Hour {
+		t.Errorf("ns.config = %+v; should keep its overrides", ns.config)
+	}
+	if v, _ := ns.Get([]byte("a")); !bytes.Equal(v, []byte("b")) {
+		t.Errorf("ns.Get(a) = %q; not b", v)
+	}
+
+	// Writes after reopening are persisted too.
+	if err := db.Put(deleted, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, _ := db.Get(deleted); !bytes.Equal(v, deleted) {
+		t.Errorf("db.Get(%q) = %q; not %q", deleted, v, deleted)
+	}
+}
+
+func TestPersistComparator(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	c.Comparator = reverseComparator{}
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, err := db.Namespace("ns", &Config{MaxKeysPerNode: 10, MaxDeltaCount: 10, Comparator: caseInsensitiveComparator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Put([]byte("A"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Namespace("rev", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Comparator = nil
+	if _, err := NewDB(&c); err != ErrComparatorMismatch {
+		t.Fatalf("NewDB with a different comparator = %+v; not ErrComparatorMismatch", err)
+	}
+
+	c.Comparator = reverseComparator{}
+	db, err = NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Comparators the DB knows are resolved when the namespace is loaded, and
+	// others when it's opened with them.
+	if cmp := db.getNamespace("rev").comparator(); cmp != (reverseComparator{}) {
+		t.Errorf("rev comparator = %#v; not reverseComparator", cmp)
+	}
+	if cmp := db.getNamespace("ns").comparator(); cmp != nil {
+		t.Errorf("ns comparator = %#v; not nil before it's opened", cmp)
+	}
+	if _, err := db.Namespace("ns", nil); err != ErrComparatorMismatch {
+		t.Fatalf("db.Namespace with a different comparator = %+v; not ErrComparatorMismatch", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.Namespace("ns", &Config{MaxKeysPerNode: 10, MaxDeltaCount: 10, Comparator: caseInsensitiveComparator{}}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	ns, err = db.Namespace("ns", &Config{MaxKeysPerNode: 10, MaxDeltaCount: 10, Comparator: caseInsensitiveComparator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ns.Get([]byte("a")); !bytes.Equal(v, []byte("a")) {
+		t.Fatalf("ns.Get(a) = %q; not a", v)
+	}
+}
+
+func TestStoreChecksum(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	s, err := openPageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, _, err := s.writePage(&page{id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 2; i++ {
+		if err := s.writeCheckpoint(&checkpoint{largestPageID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := s.checkpoint
+	tail, _, err := s.writePage(&page{id: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(off int64) {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, off+recordHeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		b[0] ^= 1
+		if _, err := f.WriteAt(b, off+recordHeaderSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A corrupt record after the latest checkpoint is treated like a torn
+	// write and truncated. Pages before it are only verified when read.
+	flip(tail)
+	flip(off)
+	s, err = openPageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.size != tail {
+		t.Errorf("s.size = %d; not %d", s.size, tail)
+	}
+	c, err := s.readCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.largestPageID != 2 {
+		t.Errorf("c.largestPageID = %d; not 2", c.largestPageID)
+	}
+	if _, err := s.readPage(off, nil); errors.Cause(err) != ErrChecksum {
+		t.Errorf("s.readPage(%d) = %+v; not ErrChecksum", off, err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupt checkpoint fails instead of falling back to an older one.
+	flip(last)
+	if _, err := openPageStore(path); errors.Cause(err) != ErrCorrupt {
+		t.Errorf("openPageStore = %+v; not ErrCorrupt", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != tail {
+		t.Errorf("os.Stat(%q) = %+v, %+v; the file should be left as it is", path, info, err)
+	}
+}
+
+func TestStoreChecksumLength(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	s, err := openPageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	off, _, err := s.writePage(&page{id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A shorter length still fits in the file, but fails the checksum.
+	b := make([]byte, 4)
+	if _, err := s.f.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(b, binary.BigEndian.Uint32(b)-1)
+	if _, err := s.f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.readPage(off, nil); errors.Cause(err) != ErrChecksum {
+		t.Errorf("s.readPage(%d) = %+v; not ErrChecksum", off, err)
+	}
+}
+
+func TestStoreCorruptLength(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		db.Put(intToKey(i), intToKey(i))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A bad length before the latest checkpoint can't be a torn write.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, 0x7fffffff)
+	_, err = f.WriteAt(b, storeHeaderSize)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDB(&c); errors.Cause(err) != ErrCorrupt {
+		t.Errorf("NewDB = %+v; not ErrCorrupt", err)
+	}
+	if after, err := os.Stat(path); err != nil || after.Size() != info.Size() {
+		t.Errorf("os.Stat(%q) = %+v, %+v; not %d bytes", path, after, err, info.Size())
+	}
+}
+
+func TestBackgroundCheckpoint(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.MaxKeysPerNode = 10
+	c.MaxDeltaCount = 1
+	c.MemoryBudget = 1
+	c.CheckpointInterval = 10 * time.Millisecond
+	c.Path = path
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < count; i++ {
+			k := intToKey(i)
+			if err := db.Put(k, intToKey(round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	want := intToKey(4)
+	check := func(db *DB) bool {
+		for i := 0; i < count; i++ {
+			if v, ok := db.Get(intToKey(i)); !ok || !bytes.Equal(v, want) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Wait for the file to be compacted, and for a copy of it, like the one a
+	// crash would leave, to hold every write.
+	crashed := path + ".crashed"
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no checkpoint with every write was compacted")
+		}
+		after, err := os.Stat(path)
+		if err != nil || os.SameFile(before, after) {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(crashed, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := DefaultConfig
+		c.Path = crashed
+		recovered, err := NewDB(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok := check(recovered)
+		if err := recovered.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			break
+		}
+	}
+
+	// Evicted pages are read from the compacted file.
+	if !check(db) {
+		t.Error("keys are missing after compaction")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.Path = path
+	db, err = NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !check(db) {
+		t.Error("keys are missing after reopening")
+	}
+}
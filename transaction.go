@@ -1,6 +1,7 @@
 package skeleton
 
 import (
+	"fmt"
 	"sync/atomic"
 	"time"
 
@@ -10,6 +11,18 @@ import (
 var (
 	// ErrTxnConflict represents a conflict when writing a transaction.
 	ErrTxnConflict = errors.New("an error occurred while committing the transaction")
+	// ErrTxnNotPending is returned when modifying a transaction that has
+	// already been committed or aborted.
+	ErrTxnNotPending = errors.New("the transaction is not pending")
+	// ErrInvalidSavepoint is returned when rolling back to a savepoint that
+	// belongs to a different transaction, or that was undone by rolling back to
+	// an earlier savepoint.
+	ErrInvalidSavepoint = errors.New("the savepoint is not valid for the transaction")
+	// ErrReadOnlyTxn is returned when writing with a read-only transaction.
+	ErrReadOnlyTxn = errors.New("the transaction is read-only")
+	// ErrNoSnapshotTime is returned when creating a read-only transaction
+	// without a time to read at.
+	ErrNoSnapshotTime = errors.New("read-only transactions need a snapshot time")
 )
 
 // TransactionStatus represents the state of the transaction.
@@ -21,6 +34,7 @@ const (
 	StatusPending
 	StatusAborted
 	StatusCommitted
+	StatusPrepared
 )
 
 // Txn represents a transaction.
@@ -28,24 +42,71 @@ type Txn struct {
 	db     *DB
 	time   time.Time
 	status TransactionStatus
+	// commitTime is the commit timestamp in nanoseconds. It's set once, before
+	// the status becomes StatusCommitted.
+	commitTime int64
+	// readOnly transactions read a snapshot at time without registering
+	// intents.
+	readOnly bool
+
+	// seq is incremented for every key written by the transaction.
+	seq int64
+	// rollbacks holds a []seqRange of writes undone by RollbackTo.
+	rollbacks atomic.Value
+}
+
+// Savepoint marks a position in a transaction that can later be rolled back
+// to.
+type Savepoint struct {
+	txn *Txn
+	seq int64
+	// rollbacks is the number of RollbackTo calls that had undone writes when
+	// the savepoint was created. Only later ones can invalidate it.
+	rollbacks int
+}
+
+// seqRange represents the writes with from < seq <= to.
+type seqRange struct {
+	from, to int64
 }
 
 // NewTxn creates a new transaction.
 func (db *DB) NewTxn() *Txn {
 	return &Txn{
 		db:     db,
-		time:   time.Now(),
+		time:   db.now(),
 		status: StatusPending,
 	}
 }
 
+// NewReadOnlyTxn creates a new transaction that reads a fixed snapshot of the
+// database at the specified time. Read-only transactions don't register read
+// intents and are never aborted by concurrent writers. Writes of transactions
+// that are pending when the snapshot is taken are skipped, since they're
+// committed after it. A time in the future is clamped to the current time.
+// ErrNoSnapshotTime is returned if at is the zero time.
+func (db *DB) NewReadOnlyTxn(at time.Time) (*Txn, error) {
+	if isZeroTime(at) {
+		return nil, ErrNoSnapshotTime
+	}
+	at = db.snapshot(at)
+	return &Txn{
+		db:       db,
+		time:     at,
+		status:   StatusPending,
+		readOnly: true,
+	}, nil
+}
+
 // Txn creates a new transaction. If no error is returned, the transaction tries
 // to be committed. If there's a conflict, the transaction will automatically be
-// retried.
+// retried. If f returns an error, the transaction is aborted, unless f prepared
+// it.
 func (db *DB) Txn(f func(*Txn) error) error {
 	for {
 		t := db.NewTxn()
 		if err := f(t); err != nil {
+			t.abort()
 			return err
 		}
 		if err := t.Commit(); err != ErrTxnConflict {
@@ -54,44 +115,195 @@ func (db *DB) Txn(f func(*Txn) error) error {
 	}
 }
 
+// finish moves a pending or prepared transaction to status. A committed
+// transaction is assigned its commit timestamp before its status changes, so
+// every reader that sees it committed also sees its commit timestamp.
 func (t *Txn) finish(status TransactionStatus) error {
-	if !atomic.CompareAndSwapInt64((*int64)(&t.status), int64(StatusPending), int64(status)) {
+	if status == StatusCommitted {
+		defer t.db.endStamp(t.db.startStamp())
+		now := t.db.now().UnixNano()
+		if !atomic.CompareAndSwapInt64(&t.commitTime, 0, now) {
+			return ErrTxnConflict
+		}
+	}
+	for {
+		old := TransactionStatus(atomic.LoadInt64((*int64)(&t.status)))
+		if !old.active() {
+			return ErrTxnConflict
+		}
+		if atomic.CompareAndSwapInt64((*int64)(&t.status), int64(old), int64(status)) {
+			return nil
+		}
+	}
+}
+
+// abort aborts a pending transaction after a conflict. A prepared transaction
+// is left as it is, since only Close may abort it.
+func (t *Txn) abort() {
+	atomic.CompareAndSwapInt64((*int64)(&t.status), int64(StatusPending), int64(StatusAborted))
+}
+
+// active returns whether a transaction with the status may still commit, and
+// thus its intents must be kept.
+func (s TransactionStatus) active() bool {
+	return s == StatusPending || s == StatusPrepared
+}
+
+// Prepare prepares the transaction for commit as the first phase of a two
+// phase commit. A prepared transaction can no longer be written to, and reads
+// from it don't add read intents. It's only aborted by Close, and its intents
+// are kept until it is committed or aborted.
+//
+// Prepared transactions are only held in memory. Their intents aren't written
+// to the page store, so they're lost when the DB is closed or crashes.
+func (t *Txn) Prepare() error {
+	if !atomic.CompareAndSwapInt64((*int64)(&t.status), int64(StatusPending), int64(StatusPrepared)) {
 		return ErrTxnConflict
 	}
 	return nil
 }
 
-// Commit commits the transaction.
+// Recover returns all transactions that have been prepared and are waiting to
+// be committed or aborted. Only transactions prepared since the DB was opened
+// are returned, since prepared transactions aren't persisted.
+func (db *DB) Recover() []*Txn {
+	var txns []*Txn
+	seen := map[*Txn]bool{}
+	for _, p := range *db.pages {
+		for d := p.next; d != nil; d = d.next {
+			if d.key == nil || d.key.txn == nil || seen[d.key.txn] {
+				continue
+			}
+			if d.key.txn.Status() == StatusPrepared {
+				seen[d.key.txn] = true
+				txns = append(txns, d.key.txn)
+			}
+		}
+	}
+	return txns
+}
+
+// Commit commits the transaction. Its writes become visible at a commit
+// timestamp that's after every timestamp handed out before.
 func (t *Txn) Commit() error {
 	return t.finish(StatusCommitted)
 }
 
+// CommitTime returns the commit timestamp of the transaction, or the zero time
+// if it hasn't been committed.
+func (t *Txn) CommitTime() time.Time {
+	if t.Status() != StatusCommitted {
+		return time.Time{}
+	}
+	return time.Unix(0, atomic.LoadInt64(&t.commitTime))
+}
+
 // Close aborts the transaction.
 func (t *Txn) Close() error {
 	return t.finish(StatusAborted)
 }
 
+// Savepoint returns a savepoint at the current position of the transaction.
+func (t *Txn) Savepoint() Savepoint {
+	rollbacks, _ := t.rollbacks.Load().([]seqRange)
+	return Savepoint{
+		txn:       t,
+		seq:       atomic.LoadInt64(&t.seq),
+		rollbacks: len(rollbacks),
+	}
+}
+
+// RollbackTo undoes every write made since the savepoint was created. The
+// transaction stays pending, and the savepoint can be rolled back to again, but
+// savepoints created after it are no longer valid.
+func (t *Txn) RollbackTo(sp Savepoint) error {
+	if sp.txn != t || t.savepointRolledBack(sp) {
+		return ErrInvalidSavepoint
+	}
+	if t.Status() != StatusPending {
+		return ErrTxnNotPending
+	}
+	seq := atomic.LoadInt64(&t.seq)
+	if seq <= sp.seq {
+		return nil
+	}
+	rollbacks, _ := t.rollbacks.Load().([]seqRange)
+	rollbacks = append(append([]seqRange{}, rollbacks...), seqRange{from: sp.seq, to: seq})
+	t.rollbacks.Store(rollbacks)
+	return nil
+}
+
+// nextSeq returns the sequence number for the next write.
+func (t *Txn) nextSeq() int64 {
+	return atomic.AddInt64(&t.seq, 1)
+}
+
+// rolledBack returns whether the write with the specified sequence number has
+// been undone by RollbackTo.
+func (t *Txn) rolledBack(seq int64) bool {
+	rollbacks, _ := t.rollbacks.Load().([]seqRange)
+	for _, r := range rollbacks {
+		if r.from < seq && seq <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// savepointRolledBack returns whether a RollbackTo made after the savepoint was
+// created undid the position it marks.
+func (t *Txn) savepointRolledBack(sp Savepoint) bool {
+	rollbacks, _ := t.rollbacks.Load().([]seqRange)
+	for _, r := range rollbacks[sp.rollbacks:] {
+		if r.from < sp.seq && sp.seq <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
 // Status returns the current status of the transaction.
-func (t Txn) Status() TransactionStatus {
-	return t.status
+func (t *Txn) Status() TransactionStatus {
+	return TransactionStatus(atomic.LoadInt64((*int64)(&t.status)))
+}
+
+// writable returns an error if the transaction can't be written to.
+func (t *Txn) writable() error {
+	if t.readOnly {
+		return ErrReadOnlyTxn
+	}
+	if t.Status() == StatusPrepared {
+		return ErrTxnNotPending
+	}
+	return nil
+}
+
+// In returns a view of the transaction that operates on the namespace. Views of
+// the same transaction on different namespaces commit or abort together. It
+// panics if the namespace belongs to a different DB than the transaction.
+func (t *Txn) In(ns *Namespace) NamespaceTxn {
+	if ns.db != t.db {
+		panic(fmt.Sprintf("namespace %q belongs to a different DB than the transaction", ns.name))
+	}
+	return NamespaceTxn{Txn: t, ns: ns}
 }
 
 // Put writes a value into the database.
 func (t *Txn) Put(k, v []byte) error {
-	return t.db.put(t, k, v)
+	return t.In(t.db.ns).Put(k, v)
 }
 
 // Delete removes a value from the database.
 func (t *Txn) Delete(k []byte) error {
-	return t.db.delete(t, k)
+	return t.In(t.db.ns).Delete(k)
 }
 
 // Get gets a value from the database.
 func (t *Txn) Get(key []byte) ([]byte, bool) {
-	return t.db.getAt(t, key, zeroTime)
+	return t.In(t.db.ns).Get(key)
 }
 
 // GetAt gets a value from the database at the specified time.
 func (t *Txn) GetAt(key []byte, at time.Time) ([]byte, bool) {
-	return t.db.getAt(t, key, at)
+	return t.In(t.db.ns).GetAt(key, at)
 }
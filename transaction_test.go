@@ -3,6 +3,7 @@ package skeleton
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/fortytw2/leaktest"
 	"github.com/pkg/errors"
@@ -50,6 +51,59 @@ func TestTransactionClose(t *testing.T) {
 	}
 }
 
+// TestTransactionCommitTime tests that writes of a transaction are versioned at
+// its commit timestamp, and that commit timestamps are increasing in commit
+// order.
+func TestTransactionCommitTime(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	c := DefaultConfig
+	c.MaxDeltaCount = 1
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, b := []byte("a"), []byte("b")
+	txn := db.NewTxn()
+	txn2 := db.NewTxn()
+	if err := txn.Put(a, a); err != nil {
+		t.Fatal(err)
+	}
+	if !txn.CommitTime().IsZero() {
+		t.Fatalf("txn.CommitTime() = %s; should be zero before commit", txn.CommitTime())
+	}
+	if err := txn2.Put(b, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	before := db.now()
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !txn.CommitTime().After(before) || !before.After(txn2.CommitTime()) {
+		t.Fatalf("commit times %s, %s out of order with %s", txn2.CommitTime(), txn.CommitTime(), before)
+	}
+
+	for i := 0; i < 2; i++ {
+		if v, ok := db.GetAt(a, before); ok {
+			t.Errorf("db.GetAt(%q, before) = %q; written before the commit", a, v)
+		}
+		if v, _ := db.GetAt(a, txn.CommitTime()); !bytes.Equal(v, a) {
+			t.Errorf("db.GetAt(%q, commit) = %q; not %q", a, v, a)
+		}
+		if v, _ := db.GetAt(b, before); !bytes.Equal(v, b) {
+			t.Errorf("db.GetAt(%q, before) = %q; not %q", b, v, b)
+		}
+
+		// Force consolidation.
+		db.consolidate(rootPage)
+	}
+}
+
 func TestTransactionPutCommit(t *testing.T) {
 	defer leaktest.Check(t)()
 	const count = 10
@@ -332,3 +386,276 @@ func TestDBTxnError(t *testing.T) {
 		t.Fatalf("db.Txn() = %v; not %v", err, want)
 	}
 }
+
+// TestTransactionSavepoint tests that rolling back to a savepoint hides the
+// later writes while keeping the transaction pending.
+func TestTransactionSavepoint(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 20
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := []byte("a")
+	b := []byte("b")
+	txn := db.NewTxn()
+	if err := txn.Put(a, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	sp := txn.Savepoint()
+	if err := txn.Put(a, []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put(b, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.RollbackTo(sp); err != nil {
+		t.Fatal(err)
+	}
+	if status := txn.Status(); status != StatusPending {
+		t.Fatalf("txn.Status() = %v; not %v", status, StatusPending)
+	}
+
+	// Rolled back writes don't conflict with other transactions.
+	txn2 := db.NewTxn()
+	if err := txn2.Put(b, []byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := txn.Get(a); !bytes.Equal(v, []byte{1}) {
+		t.Fatalf("txn.Get(%q) = %q; not %q", a, v, []byte{1})
+	}
+	if v, ok := txn.Get(b); ok {
+		t.Fatalf("txn.Get(%q) = %q; should not be ok", b, v)
+	}
+
+	// Force consolidation.
+	db.consolidate(rootPage)
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get(a); !bytes.Equal(v, []byte{1}) {
+		t.Fatalf("db.Get(%q) = %q; not %q", a, v, []byte{1})
+	}
+	if v, ok := db.Get(b); ok {
+		t.Fatalf("db.Get(%q) = %q; should not be ok", b, v)
+	}
+}
+
+// TestTransactionSavepointInvalid tests that savepoints can only be used on
+// their own pending transaction, and not after an earlier savepoint was rolled
+// back to.
+func TestTransactionSavepointInvalid(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	txn := db.NewTxn()
+	txn2 := db.NewTxn()
+	if err := txn.RollbackTo(txn2.Savepoint()); err != ErrInvalidSavepoint {
+		t.Fatalf("txn.RollbackTo() = %v; not %v", err, ErrInvalidSavepoint)
+	}
+	a := []byte("a")
+	sp := txn.Savepoint()
+	if err := txn.Put(a, a); err != nil {
+		t.Fatal(err)
+	}
+	sp2 := txn.Savepoint()
+	if err := txn.Put(a, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.RollbackTo(sp); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.RollbackTo(sp2); err != ErrInvalidSavepoint {
+		t.Fatalf("txn.RollbackTo(sp2) = %v; not %v", err, ErrInvalidSavepoint)
+	}
+	if err := txn.RollbackTo(sp); err != nil {
+		t.Fatalf("txn.RollbackTo(sp) = %v; a savepoint can be rolled back to again", err)
+	}
+	sp3 := txn.Savepoint()
+	if err := txn.Put(a, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.RollbackTo(sp3); err != nil {
+		t.Fatalf("txn.RollbackTo(sp3) = %v; a savepoint created after a rollback is valid", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.RollbackTo(sp); err != ErrTxnNotPending {
+		t.Fatalf("txn.RollbackTo() = %v; not %v", err, ErrTxnNotPending)
+	}
+}
+
+// TestTransactionReadOnly tests that read-only transactions read a fixed
+// snapshot and are never aborted by writers.
+func TestTransactionReadOnly(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	k := []byte("key")
+	if err := db.Put(k, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	// A write that's pending when the snapshot is taken isn't in it, even
+	// once it's committed.
+	pendingKey := []byte("pending")
+	pending := db.NewTxn()
+	if err := pending.Put(pendingKey, pendingKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.NewReadOnlyTxn(time.Time{}); err != ErrNoSnapshotTime {
+		t.Fatalf("db.NewReadOnlyTxn(time.Time{}) = %v; not %v", err, ErrNoSnapshotTime)
+	}
+	txn, err := db.NewReadOnlyTxn(db.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := txn.Get(k); !bytes.Equal(v, []byte{1}) {
+		t.Fatalf("txn.Get(%q) = %q; not %q", k, v, []byte{1})
+	}
+
+	// A pending writer doesn't abort the read-only transaction.
+	txn2 := db.NewTxn()
+	if err := txn2.Put(k, []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := txn.Get(k); !bytes.Equal(v, []byte{1}) {
+		t.Fatalf("txn.Get(%q) = %q; not %q", k, v, []byte{1})
+	}
+	if err := txn2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pending.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := txn.Get(k); !bytes.Equal(v, []byte{1}) {
+		t.Fatalf("txn.Get(%q) = %q; not %q", k, v, []byte{1})
+	}
+	if v, _ := txn.GetAt(k, time.Time{}); !bytes.Equal(v, []byte{1}) {
+		t.Fatalf("txn.GetAt(%q, time.Time{}) = %q; not %q", k, v, []byte{1})
+	}
+	if v, ok := txn.Get(pendingKey); ok {
+		t.Fatalf("txn.Get(%q) = %q; committed after the snapshot", pendingKey, v)
+	}
+	if status := txn.Status(); status != StatusPending {
+		t.Fatalf("txn.Status() = %v; not %v", status, StatusPending)
+	}
+
+	if err := txn.Put(k, k); err != ErrReadOnlyTxn {
+		t.Fatalf("txn.Put() = %v; not %v", err, ErrReadOnlyTxn)
+	}
+	if err := txn.Delete(k); err != ErrReadOnlyTxn {
+		t.Fatalf("txn.Delete() = %v; not %v", err, ErrReadOnlyTxn)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTransactionPrepare tests that prepared transactions keep their intents
+// through consolidation and can be recovered and committed.
+func TestTransactionPrepare(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 20
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < count; i++ {
+		k := intToKey(i)
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k := []byte("a")
+	txn := db.NewTxn()
+	if err := txn.Put(k, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Prepare(); err != ErrTxnConflict {
+		t.Fatalf("txn.Prepare() = %v; not %v", err, ErrTxnConflict)
+	}
+	if err := txn.Put(k, k); err != ErrTxnNotPending {
+		t.Fatalf("txn.Put() = %v; not %v", err, ErrTxnNotPending)
+	}
+
+	// Force consolidation.
+	db.consolidate(rootPage)
+
+	if v, ok := db.Get(k); ok {
+		t.Fatalf("db.Get(%q) = %q; should not be ok", k, v)
+	}
+
+	// Prepared intents still conflict with other writers.
+	txn2 := db.NewTxn()
+	if err := txn2.Put(k, []byte{1}); err != ErrTxnConflict {
+		t.Fatalf("txn2.Put() = %v; not %v", err, ErrTxnConflict)
+	}
+
+	// Reads don't add intents to a prepared transaction, so they can't abort it
+	// either.
+	b := []byte("b")
+	txn3 := db.NewTxn()
+	if err := txn3.Put(b, b); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := txn.Get(k); !bytes.Equal(v, k) {
+		t.Fatalf("txn.Get(%q) = %q; not %q", k, v, k)
+	}
+	if v, ok := txn.Get(b); ok {
+		t.Fatalf("txn.Get(%q) = %q; should not be ok", b, v)
+	}
+	if status := txn.Status(); status != StatusPrepared {
+		t.Fatalf("txn.Status() = %v; not %v", status, StatusPrepared)
+	}
+	if err := txn3.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	txns := db.Recover()
+	if len(txns) != 1 || txns[0] != txn {
+		t.Fatalf("db.Recover() = %+v; not [%p]", txns, txn)
+	}
+	if err := txns[0].Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if txns := db.Recover(); len(txns) != 0 {
+		t.Fatalf("db.Recover() = %+v; not empty", txns)
+	}
+	if v, _ := db.Get(k); !bytes.Equal(v, k) {
+		t.Fatalf("db.Get(%q) = %q; not %q", k, v, k)
+	}
+}
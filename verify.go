@@ -0,0 +1,51 @@
+package skeleton
+
+import "github.com/pkg/errors"
+
+// CorruptPage is a page whose copy in the page store can't be read.
+type CorruptPage struct {
+	ID     int64
+	Offset int64
+	Err    error
+}
+
+// Verify reads the copy in the page store of every page in the mapping table,
+// and returns the pages that fail their checksum or can't be decoded. Pages
+// that are only in memory have nothing to verify. Pages aren't loaded into
+// memory by Verify, so it can scrub the page store while the DB is in use.
+// Reading a corrupt page otherwise fails with its error, which reads that
+// can't return an error, like Get, log before treating its keys as not found.
+func (db *DB) Verify() []CorruptPage {
+	if db.store == nil {
+		return nil
+	}
+	var corrupt []CorruptPage
+	pages := *db.pages
+	for i, slot := range pages {
+		d := slot.next
+		if d == nil {
+			continue
+		}
+		db.storeLock.RLock()
+		p := d.getPage()
+		offset := p.offset
+		if offset == 0 {
+			db.storeLock.RUnlock()
+			continue
+		}
+		id := pageID(i + 1)
+		stored, err := db.store.readPage(offset, p.ns)
+		db.storeLock.RUnlock()
+		if err == nil && stored.id != id {
+			err = errors.Wrapf(ErrCorrupt, "record at %d is page %d", offset, stored.id)
+		}
+		if err != nil {
+			corrupt = append(corrupt, CorruptPage{
+				ID:     int64(id),
+				Offset: offset,
+				Err:    err,
+			})
+		}
+	}
+	return corrupt
+}
@@ -0,0 +1,193 @@
+package skeleton
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+)
+
+// corruptPage flips a bit in the record of the page at off in the page store.
+func corruptPage(t *testing.T, path string, off int64) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	pos := off + recordHeaderSize + 3
+	if _, err := f.ReadAt(b, pos); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 1
+	if _, err := f.WriteAt(b, pos); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// evictLeaf checkpoints the database and evicts the data page holding k, and
+// returns the offset of the page in the page store.
+func evictLeaf(t *testing.T, db *DB, k []byte) int64 {
+	t.Helper()
+
+	id, _, _, err := db.findLeaf(db.ns, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	// The first call may only clear the referenced bit.
+	db.evictPage(id)
+	db.evictPage(id)
+	p := db.getPage(id).next.page
+	if !p.stub {
+		t.Fatalf("page %d wasn't evicted", id)
+	}
+	return p.offset
+}
+
+func TestVerify(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	db := splitDBConfig(t, &c, count)
+	if corrupt := db.Verify(); len(corrupt) > 0 {
+		t.Errorf("db.Verify() = %+v; should be empty", corrupt)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if corrupt := db.Verify(); len(corrupt) > 0 {
+		t.Errorf("db.Verify() = %+v; should be empty", corrupt)
+	}
+
+	// Flip a bit in the page holding a key.
+	k := intToKey(42)
+	id, _, _, _ := db.findLeaf(db.ns, k)
+	off := db.getPage(id).next.page.offset
+	corruptPage(t, path, off)
+
+	corrupt := db.Verify()
+	if len(corrupt) != 1 || corrupt[0].ID != int64(id) || corrupt[0].Offset != off || errors.Cause(corrupt[0].Err) != ErrChecksum {
+		t.Fatalf("db.Verify() = %+v; should report page %d", corrupt, id)
+	}
+
+	// Rewriting the page fixes it.
+	for i := 0; i < 2; i++ {
+		if err := db.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.consolidate(id)
+	if corrupt := db.Verify(); len(corrupt) > 0 {
+		t.Errorf("db.Verify() = %+v; should be empty", corrupt)
+	}
+	if v, _ := db.Get(k); !bytes.Equal(v, k) {
+		t.Errorf("db.Get(%q) = %q; not %q", k, v, k)
+	}
+}
+
+// TestVerifyReadErrors tests that reading a corrupt page returns an error
+// instead of panicking.
+func TestVerifyReadErrors(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	db, err := NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := []byte("a")
+	if err := db.Put(k, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the root page before it's read into memory.
+	db, err = NewDB(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	corruptPage(t, path, db.getPage(rootPage).next.page.offset)
+
+	if err := db.Put(k, k); errors.Cause(err) != ErrChecksum {
+		t.Errorf("db.Put = %+v; not ErrChecksum", err)
+	}
+	if v, ok := db.Get(k); ok {
+		t.Errorf("db.Get(%q) = %q; should not be found", k, v)
+	}
+	it := db.NewIterator(nil, nil)
+	if it.Next() {
+		t.Errorf("it.Next() = true; should fail")
+	}
+	if errors.Cause(it.Err()) != ErrChecksum {
+		t.Errorf("it.Err() = %+v; not ErrChecksum", it.Err())
+	}
+	if corrupt := db.Verify(); len(corrupt) != 1 || corrupt[0].ID != int64(rootPage) {
+		t.Errorf("db.Verify() = %+v; should report page %d", corrupt, rootPage)
+	}
+}
+
+// TestVerifyReadIntentError tests that a transaction's read of a key whose page
+// has become corrupt since it was loaded fails instead of panicking.
+func TestVerifyReadIntentError(t *testing.T) {
+	defer leaktest.Check(t)()
+	const count = 100
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	c := DefaultConfig
+	c.Path = path
+	db := splitDBConfig(t, &c, count)
+	defer db.Close()
+
+	txn := db.NewTxn()
+	defer txn.Close()
+	it := txn.NewIterator(nil, nil)
+	if !it.Next() {
+		t.Fatalf("it.Next() = false; %+v", it.Err())
+	}
+	// The iterator has read the rest of the page, but adds the read intent of
+	// each key as it moves on to it. Split the page, so the keys past the read
+	// intent are on a page without deltas that can be evicted.
+	id, _, _, err := db.findLeaf(db.ns, it.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.consolidate(id)
+	db.split(id)
+	last := it.entries[len(it.entries)-1].key
+	corruptPage(t, path, evictLeaf(t, db, last))
+	for it.Next() {
+		if bytes.Equal(it.Key(), last) {
+			t.Fatalf("it.Next() = true for %q; should fail", last)
+		}
+	}
+	if errors.Cause(it.Err()) != ErrChecksum {
+		t.Errorf("it.Err() = %+v; not ErrChecksum", it.Err())
+	}
+}